@@ -0,0 +1,194 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	// TODO: Replace with github.com/goinvest/iexcloud once https://github.com/goinvest/iexcloud/issues/41 is closed
+	iex "github.com/vglafirov/iexcloud"
+
+	"github.com/vglafirov/iexcloud_exporter/pkg/calendar"
+	"github.com/vglafirov/iexcloud_exporter/pkg/config"
+	"github.com/vglafirov/iexcloud_exporter/pkg/model"
+	"github.com/vglafirov/iexcloud_exporter/pkg/transport"
+)
+
+// backfillOpts are the flags of the backfill command
+type backfillOpts struct {
+	symbols   []string
+	timeRange string
+	output    string
+}
+
+// backfillSample one sample of the OpenMetrics file
+type backfillSample struct {
+	symbol  string
+	session string
+	time    time.Time
+	value   float64
+}
+
+// runBackfill writes the daily bars of the symbols to the output file, or to
+// the standard output if it is "-"
+func runBackfill(opts backfillOpts, iexOpts iexcloudOpts, logger log.Logger) error {
+	var symbols []string
+	for _, s := range opts.symbols {
+		symbols = append(symbols, strings.Split(s, ",")...)
+	}
+	if err := config.ValidateSymbols(symbols); err != nil {
+		return err
+	}
+	timeframe := iex.HistoricalTimeFrame(opts.timeRange)
+	if !timeframe.Valid() {
+		return fmt.Errorf("invalid range %q", opts.timeRange)
+	}
+	token, err := iexOpts.token()
+	if err != nil {
+		return err
+	}
+	e, err := iexOpts.endpointURL()
+	if err != nil {
+		return err
+	}
+	httpClient := &http.Client{
+		Transport: transport.NewRetry(
+			transport.NewLimiter(transport.NewErrors(http.DefaultTransport), iexOpts.rateLimit, iexOpts.dailyBudget),
+			iexOpts.maxRetries, iexOpts.retryBackoff,
+		),
+		Timeout: iexOpts.timeout,
+	}
+	client := iex.NewClient(token, e.String(), iex.WithHTTPClient(httpClient))
+
+	out := os.Stdout
+	if opts.output != "-" {
+		if out, err = os.Create(opts.output); err != nil {
+			return err
+		}
+	}
+	w := bufio.NewWriter(out)
+	err = backfill(client, symbols, timeframe, w, logger)
+	if flushErr := w.Flush(); err == nil {
+		err = flushErr
+	}
+	if out != os.Stdout {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// backfill writes the daily bars of the symbols in the OpenMetrics text
+// format, under the names of the ohlc and previous groups. A bar is the
+// today session up to its official close, and the previous session from the
+// start of the next trading day.
+func backfill(client *iex.Client, symbols []string, timeframe iex.HistoricalTimeFrame, w io.Writer, logger log.Logger) error {
+	location, err := time.LoadLocation(calendar.DefaultTimezone)
+	if err != nil {
+		return err
+	}
+
+	samples := make(map[*prometheus.Desc][]backfillSample)
+	for _, symbol := range symbols {
+		bars, err := client.HistoricalPrices(symbol, timeframe, nil)
+		if err != nil {
+			return fmt.Errorf("cannot fetch the %s chart of %s: %w", timeframe, symbol, transport.Redact(err))
+		}
+		level.Info(logger).Log("msg", "chart fetched", "symbol", symbol, "range", timeframe, "bars", len(bars))
+
+		var previous *iex.HistoricalDataPoint
+		for i := range bars {
+			bar := &bars[i]
+			day, err := time.ParseInLocation("2006-01-02", bar.Date, location)
+			if err != nil {
+				return fmt.Errorf("invalid date %q in the chart of %s", bar.Date, symbol)
+			}
+			closed := day.Add(16 * time.Hour)
+			for desc, value := range map[*prometheus.Desc]float64{
+				model.OHLCOpen:  bar.Open,
+				model.OHLCHigh:  bar.High,
+				model.OHLCLow:   bar.Low,
+				model.OHLCClose: bar.Close,
+			} {
+				samples[desc] = append(samples[desc], backfillSample{symbol, model.SessionToday, closed, value})
+			}
+			if previous != nil {
+				for desc, value := range map[*prometheus.Desc]float64{
+					model.OHLCOpen:   previous.Open,
+					model.OHLCHigh:   previous.High,
+					model.OHLCLow:    previous.Low,
+					model.OHLCClose:  previous.Close,
+					model.OHLCVolume: float64(previous.Volume),
+				} {
+					samples[desc] = append(samples[desc], backfillSample{symbol, model.SessionPrevious, day, value})
+				}
+			}
+			previous = bar
+		}
+	}
+
+	descs := make([]*prometheus.Desc, 0, len(samples))
+	for desc := range samples {
+		descs = append(descs, desc)
+	}
+	sort.Slice(descs, func(i, j int) bool { return model.DescName(descs[i]) < model.DescName(descs[j]) })
+
+	// The samples of a series are contiguous and in time order
+	for _, desc := range descs {
+		name, s := model.DescName(desc), samples[desc]
+		sort.SliceStable(s, func(i, j int) bool {
+			if s[i].symbol != s[j].symbol {
+				return s[i].symbol < s[j].symbol
+			}
+			if s[i].session != s[j].session {
+				return s[i].session < s[j].session
+			}
+			return s[i].time.Before(s[j].time)
+		})
+		if _, err := fmt.Fprintf(w, "# TYPE %s gauge\n", name); err != nil {
+			return err
+		}
+		for _, sample := range s {
+			if _, err := fmt.Fprintf(w, "%s{session=%q,symbol=%q} %s %d\n", name, sample.session, sample.symbol,
+				strconv.FormatFloat(sample.value, 'g', -1, 64), sample.time.Unix()); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = io.WriteString(w, "# EOF\n")
+	return err
+}
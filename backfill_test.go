@@ -0,0 +1,98 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/vglafirov/iexcloud_exporter/pkg/iextest"
+	"github.com/vglafirov/iexcloud_exporter/pkg/transport"
+)
+
+func TestBackfill(t *testing.T) {
+	server := httptest.NewServer(iextest.NewServer(iextest.DefaultFixtures().Merge(transport.Fixtures{
+		"/stock/aapl/chart/5y": `[{"date":"2019-11-07","open":258.74,"close":259.43,"high":260.35,"low":258.11,"volume":23735083},{"date":"2019-11-08","open":258.69,"close":260.14,"high":260.44,"low":256.85,"volume":17520495}]`,
+		"/stock/msft/chart/5y": `[{"date":"2019-11-08","open":143.98,"close":147.31,"high":147.37,"low":143.22,"volume":20079434}]`,
+	})))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "iexcloud_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "backfill.om")
+
+	iexOpts := iexcloudOpts{apiToken: "test", endpoint: server.URL + "/stable/", timeout: 5 * time.Second}
+	opts := backfillOpts{symbols: []string{"aapl,msft"}, timeRange: "5y", output: output}
+	if err := runBackfill(opts, iexOpts, log.NewNopLogger()); err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2019-11-07 16:00 EST is 1573160400, 2019-11-08 00:00 EST is 1573189200
+	expected := `# TYPE iexcloud_ohlc_close gauge
+iexcloud_ohlc_close{session="previous",symbol="aapl"} 259.43 1573189200
+iexcloud_ohlc_close{session="today",symbol="aapl"} 259.43 1573160400
+iexcloud_ohlc_close{session="today",symbol="aapl"} 260.14 1573246800
+iexcloud_ohlc_close{session="today",symbol="msft"} 147.31 1573246800
+# TYPE iexcloud_ohlc_high gauge
+iexcloud_ohlc_high{session="previous",symbol="aapl"} 260.35 1573189200
+iexcloud_ohlc_high{session="today",symbol="aapl"} 260.35 1573160400
+iexcloud_ohlc_high{session="today",symbol="aapl"} 260.44 1573246800
+iexcloud_ohlc_high{session="today",symbol="msft"} 147.37 1573246800
+# TYPE iexcloud_ohlc_low gauge
+iexcloud_ohlc_low{session="previous",symbol="aapl"} 258.11 1573189200
+iexcloud_ohlc_low{session="today",symbol="aapl"} 258.11 1573160400
+iexcloud_ohlc_low{session="today",symbol="aapl"} 256.85 1573246800
+iexcloud_ohlc_low{session="today",symbol="msft"} 143.22 1573246800
+# TYPE iexcloud_ohlc_open gauge
+iexcloud_ohlc_open{session="previous",symbol="aapl"} 258.74 1573189200
+iexcloud_ohlc_open{session="today",symbol="aapl"} 258.74 1573160400
+iexcloud_ohlc_open{session="today",symbol="aapl"} 258.69 1573246800
+iexcloud_ohlc_open{session="today",symbol="msft"} 143.98 1573246800
+# TYPE iexcloud_ohlc_volume gauge
+iexcloud_ohlc_volume{session="previous",symbol="aapl"} 2.3735083e+07 1573189200
+# EOF
+`
+	if string(body) != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, body)
+	}
+
+	opts.timeRange = "7y"
+	if err := runBackfill(opts, iexOpts, log.NewNopLogger()); err == nil || err.Error() != `invalid range "7y"` {
+		t.Errorf("expected an invalid range error, got %v", err)
+	}
+}
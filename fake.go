@@ -0,0 +1,67 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/vglafirov/iexcloud_exporter/pkg/iextest"
+	"github.com/vglafirov/iexcloud_exporter/pkg/transport"
+)
+
+// fakeOpts are the flags of the fake-iexcloud command
+type fakeOpts struct {
+	listenAddress string
+	fixtures      string
+	faults        []string
+}
+
+// runFake serves the fake IEX Cloud API until the listener fails
+func runFake(opts fakeOpts, logger log.Logger) error {
+	fixtures := iextest.DefaultFixtures()
+	if opts.fixtures != "" {
+		loaded, err := transport.LoadFixtures(opts.fixtures)
+		if err != nil {
+			return err
+		}
+		fixtures = fixtures.Merge(loaded)
+		level.Info(logger).Log("msg", "fixtures loaded", "dir", opts.fixtures, "count", len(loaded))
+	}
+
+	server := iextest.NewServer(fixtures)
+	for _, s := range opts.faults {
+		f, err := iextest.ParseFault(s)
+		if err != nil {
+			return err
+		}
+		server.Inject(f)
+	}
+
+	level.Info(logger).Log("msg", "serving the fake IEX Cloud API", "address", opts.listenAddress)
+	return http.ListenAndServe(opts.listenAddress, server)
+}
@@ -3,9 +3,11 @@ module github.com/vglafirov/iexcloud_exporter
 require (
 	github.com/go-kit/kit v0.8.0
 	github.com/prometheus/client_golang v1.1.0
+	github.com/prometheus/client_model v0.0.0-20190129233127-fd36f4220a90
 	github.com/prometheus/common v0.6.0
 	github.com/vglafirov/iexcloud v0.0.0-20191118101153-9dea52d7f639
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
+	gopkg.in/yaml.v2 v2.2.4
 )
 
 go 1.13
@@ -25,18 +25,26 @@ SOFTWARE.
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
-	"regexp"
+	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
+	"github.com/vglafirov/iexcloud_exporter/pkg/calendar"
 	"github.com/vglafirov/iexcloud_exporter/pkg/config"
 	"github.com/vglafirov/iexcloud_exporter/pkg/model"
+	"github.com/vglafirov/iexcloud_exporter/pkg/poller"
+	"github.com/vglafirov/iexcloud_exporter/pkg/schedule"
+	"github.com/vglafirov/iexcloud_exporter/pkg/transport"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -57,6 +65,16 @@ var (
 		"Was the last query of iexcloud successful.",
 		nil, nil,
 	)
+	lastReloadSuccessful = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "config", "last_reload_successful"),
+		"Whether the last configuration reload attempt was successful.",
+		nil, nil,
+	)
+	lastReloadSuccess = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "config", "last_reload_success_timestamp_seconds"),
+		"Timestamp of the last successful configuration reload.",
+		nil, nil,
+	)
 )
 
 type promHTTPLogger struct {
@@ -68,176 +86,435 @@ func (l promHTTPLogger) Println(v ...interface{}) {
 }
 
 type iexcloudOpts struct {
-	endpoint   string
-	apiToken   string
-	apiVersion string
-	configPath string
+	endpoint        string
+	apiToken        string
+	apiTokenFile    string
+	apiVersion      string
+	configPath      string
+	refreshInterval time.Duration
+	rateLimit       float64
+	dailyBudget     float64
+	maxRetries      int
+	retryBackoff    time.Duration
+	breakerFailures int
+	breakerCooldown time.Duration
+	timeout         time.Duration
+	recordDir       string
+	replayDir       string
 }
 
 // Exporter object
 type Exporter struct {
-	Client   *iex.Client
-	kvPrefix string
-	kvFilter *regexp.Regexp
-	logger   log.Logger
-	config   json.RawMessage
+	logger log.Logger
+	opts   iexcloudOpts
+	// transport exposes the metrics of the HTTP middlewares
+	transport []prometheus.Collector
+	newClient model.ClientFunc
+
+	// reload serialises the config reloads
+	reload sync.Mutex
+
+	mtx     sync.RWMutex
+	poller  *poller.Poller
+	modules map[string]config.Module
+	// filter is the global filter, applied to the probes
+	filter config.Filter
+	// token is the command line API token
+	token string
+	// market is set when the real-time groups follow the calendar
+	market   bool
+	calendar *calendar.Calendar
+	// ctx is the context of Run, cancel stops the running poller
+	ctx                  context.Context
+	cancel               context.CancelFunc
+	lastReloadSuccessful bool
+	lastReloadSuccess    time.Time
 }
 
 func (o iexcloudOpts) String() string {
-	return fmt.Sprintf("Endpoint: %s\n API version: %s", o.endpoint, o.apiVersion)
+	return fmt.Sprintf("Endpoint: %s\n API version: %s\n Refresh interval: %s\n Timeout: %s\n Rate limit: %g/s\n Daily message budget: %g",
+		o.endpoint, o.apiVersion, o.refreshInterval, o.timeout, o.rateLimit, o.dailyBudget)
 }
 
-// Describe describes all the metrics ever exported by the Consul exporter. It
+// Describe describes all the metrics ever exported by the IEX Cloud exporter. It
 // implements prometheus.Collector.
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- up
-	ch <- model.PriceMetric
-	ch <- model.DividendsMetric
-	ch <- model.MarketcapStatsMetric
-	ch <- model.Week52High
-	ch <- model.Week52Low
-	ch <- model.Week52Change
-	ch <- model.SharesOutstanding
-	ch <- model.Avg30Volume
-	ch <- model.Avg10Volume
-	ch <- model.Float
-	ch <- model.Employees
-	ch <- model.TTMEPS
-	ch <- model.TTMDividendRate
-	ch <- model.DividendYield
-	ch <- model.PERatio
-	ch <- model.Beta
-	ch <- model.Day200MovingAvg
-	ch <- model.Day50MovingAvg
-	ch <- model.MaxChangePercent
-	ch <- model.Year5ChangePercent
-	ch <- model.Year2ChangePercent
-	ch <- model.Year1ChangePercent
-	ch <- model.YTDChangePercent
-	ch <- model.Month6ChangePercent
-	ch <- model.Month3ChangePercent
-	ch <- model.Month1ChangePercent
-	ch <- model.Day30ChangePercent
-	ch <- model.Day5ChangePercent
+	ch <- lastReloadSuccessful
+	ch <- lastReloadSuccess
+	for _, c := range e.transport {
+		c.Describe(ch)
+	}
+	e.currentPoller().Describe(ch)
+	e.calendar.Describe(ch)
+	model.Describe(ch)
 }
 
-// Collect fetches the stats from configured Consul location and delivers them
-// as Prometheus metrics. It implements prometheus.Collector.
+// Collect delivers the latest snapshot of the configured metric groups as
+// Prometheus metrics. It implements prometheus.Collector.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	ok := e.collectMetrics(ch)
-	level.Info(e.logger).Log("msg", "collecting metrics", "result", ok)
-
-	if ok {
-		ch <- prometheus.MustNewConstMetric(
-			up, prometheus.GaugeValue, 1.0,
-		)
-	} else {
-		ch <- prometheus.MustNewConstMetric(
-			up, prometheus.GaugeValue, 0.0,
-		)
+	ctx, cancel := context.WithTimeout(context.Background(), e.opts.timeout)
+	defer cancel()
+	e.collect(ctx, ch)
+}
+
+// collect delivers the metrics, the groups refreshed on every scrape are
+// collected within the deadline of ctx
+func (e *Exporter) collect(ctx context.Context, ch chan<- prometheus.Metric) {
+	p := e.currentPoller()
+	p.CollectContext(ctx, ch)
+	for _, c := range e.transport {
+		c.Collect(ch)
+	}
+
+	value := 0.0
+	if p.Up() {
+		value = 1
 	}
+	ch <- prometheus.MustNewConstMetric(
+		up, prometheus.GaugeValue, value,
+	)
+
+	e.mtx.RLock()
+	successful, success, market := e.lastReloadSuccessful, e.lastReloadSuccess, e.market
+	e.mtx.RUnlock()
+	if market {
+		e.calendar.Collect(ch)
+	}
+	value = 0
+	if successful {
+		value = 1
+	}
+	ch <- prometheus.MustNewConstMetric(
+		lastReloadSuccessful, prometheus.GaugeValue, value,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		lastReloadSuccess, prometheus.GaugeValue, float64(success.Unix()),
+	)
+}
+
+// scrape binds the exporter to the context of a single scrape
+type scrape struct {
+	*Exporter
+	ctx context.Context
 }
 
-func exists(m config.Metric, s string) bool {
-	_, ok := m[s]
-	return ok
+// Collect implements prometheus.Collector.
+func (s scrape) Collect(ch chan<- prometheus.Metric) {
+	s.collect(s.ctx, ch)
+}
+
+// scrapeTimeout returns the timeout of a scrape, as sent by Prometheus minus
+// the offset, or the fallback if Prometheus did not send one
+func scrapeTimeout(r *http.Request, fallback, offset time.Duration) time.Duration {
+	v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if v == "" {
+		return fallback
+	}
+	seconds, err := strconv.ParseFloat(v, 64)
+	if err != nil || seconds <= 0 {
+		return fallback
+	}
+	timeout := time.Duration(seconds*float64(time.Second)) - offset
+	if timeout <= 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	return timeout
 }
 
-func (e *Exporter) collectMetrics(ch chan<- prometheus.Metric) bool {
-	var cfg config.Config
-
-	if err := json.Unmarshal(e.config, &cfg); err != nil {
-		level.Error(e.logger).Log("msg", "cannot read JSON data", "err", err)
-	}
-	total := len(cfg.Metrics)
-	var wg sync.WaitGroup
-	wg.Add(total)
-
-	// Concurently collecting all the metrics
-	for count, m := range cfg.Metrics {
-		metric := m
-		go func(count int) {
-			defer wg.Done()
-			switch {
-			case exists(metric, "price"):
-				var price model.Price
-				price.Client = e.Client
-				price.Symbols = model.SetPriceParams(metric["price"])
-
-				level.Info(e.logger).Log("msg", "collecting Price for", "symbols", len(price.Symbols))
-
-				if err := price.API(ch); err != nil {
-					level.Error(e.logger).Log("msg", "cannot collect Price data", "err", err)
-				}
-			case exists(metric, "dividends"):
-				var dividends model.Dividend
-				dividends.Client = e.Client
-				level.Info(e.logger).Log("msg", "collecting dividents metrics")
-				if err := model.SetDividendsParams(&dividends, metric["dividends"]); err != nil {
-					level.Error(e.logger).Log("msg", "cannot collect Dividends data", "err", err)
-				}
-				if err := dividends.API(ch); err != nil {
-					level.Error(e.logger).Log("msg", "cannot collect dividends data", "err", err)
-				}
-			case exists(metric, "keystats"):
-				var keystats model.KeyStats
-				keystats.Client = e.Client
-				level.Info(e.logger).Log("msg", "collecting keystats metrics")
-				if err := model.SetKeyStatsParams(&keystats, metric["keystats"]); err != nil {
-					level.Error(e.logger).Log("msg", "cannot collect Key Stats data", "err", err)
-				}
-				if err := keystats.API(ch); err != nil {
-					level.Error(e.logger).Log("msg", "cannot collect keystats data", "err", err)
-				}
-			default:
-				level.Warn(e.logger).Log("msg", "no metrics configured")
+// Handler serves the metrics, the groups refreshed on every scrape are
+// collected within the scrape timeout
+func (e *Exporter) Handler(offset time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), scrapeTimeout(r, e.opts.timeout, offset))
+		defer cancel()
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(scrape{Exporter: e, ctx: ctx})
+		promhttp.HandlerFor(
+			prometheus.Gatherers{prometheus.DefaultGatherer, registry},
+			promhttp.HandlerOpts{
+				ErrorLog: &promHTTPLogger{
+					logger: e.logger,
+				},
+			},
+		).ServeHTTP(w, r)
+	})
+}
+
+// Run refreshes the metric groups in the background until ctx is cancelled
+func (e *Exporter) Run(ctx context.Context) {
+	e.mtx.Lock()
+	e.ctx = ctx
+	e.start()
+	e.mtx.Unlock()
+
+	<-ctx.Done()
+}
+
+// start runs the poller, and the calendar if enabled, in the background,
+// stopping the previous ones. It must be called with the lock held.
+func (e *Exporter) start() {
+	if e.cancel != nil {
+		e.cancel()
+	}
+	var ctx context.Context
+	ctx, e.cancel = context.WithCancel(e.ctx)
+	go e.poller.Run(ctx)
+	if e.market {
+		token := e.token
+		go e.calendar.Run(ctx, func(ctx context.Context) *iex.Client {
+			return e.newClient(ctx, token)
+		})
+	}
+}
+
+// apply switches to a loaded config. It must be called with the lock held.
+func (e *Exporter) apply(cfg config.Config, groups []poller.Group, token string) {
+	e.market = cfg.Market != nil
+	if e.market {
+		// Checked by loadConfig
+		e.calendar.Configure(cfg.Market.Timezone, cfg.Market.Sessions)
+		for i := range groups {
+			if _, ok := groups[i].Collector.(model.RealTimeCollector); ok {
+				groups[i].Market = e.calendar
 			}
-		}(count)
+		}
 	}
+	p := poller.New(e.newClient, groups, e.opts.timeout, e.logger)
+	if e.poller != nil {
+		p.Carry(e.poller)
+	}
+	e.poller = p
+	e.modules = cfg.Modules
+	e.filter = cfg.Filter
+	e.token = token
+	if e.ctx != nil {
+		e.start()
+	}
+}
 
-	level.Info(e.logger).Log("msg", "waiting for metrics to be collected", "total", total)
+func (e *Exporter) currentPoller() *poller.Poller {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	return e.poller
+}
 
-	wg.Wait()
+// Reload reads the config file again. The new config replaces the running one
+// only if it is valid, the metric groups are then refreshed right away.
+func (e *Exporter) Reload() error {
+	e.reload.Lock()
+	defer e.reload.Unlock()
 
-	return true
+	cfg, groups, token, err := loadConfig(e.opts)
+	if err != nil {
+		e.mtx.Lock()
+		e.lastReloadSuccessful = false
+		e.mtx.Unlock()
+		return err
+	}
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.apply(cfg, groups, token)
+	e.lastReloadSuccessful = true
+	e.lastReloadSuccess = time.Now()
+	return nil
 }
 
-// NewExporter returns an initialized Exporter.
-func NewExporter(opts iexcloudOpts, kvPrefix, kvFilter string, logger log.Logger) (*Exporter, error) {
-	endpoint := opts.endpoint
+// ReloadHandler reloads the config on POST requests
+func (e *Exporter) ReloadHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := e.Reload(); err != nil {
+			level.Error(e.logger).Log("msg", "error reloading the config", "err", err)
+			http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+			return
+		}
+		level.Info(e.logger).Log("msg", "config reloaded", "config", e.opts.configPath)
+	})
+}
+
+// endpointURL returns the URL of the IEX Cloud API, the endpoint is either a
+// host name or a URL
+func (o iexcloudOpts) endpointURL() (*url.URL, error) {
+	endpoint := o.endpoint
 	if !strings.Contains(endpoint, "://") {
-		endpoint = "https://" + endpoint + "/" + opts.apiVersion + "/"
+		endpoint = "https://" + endpoint + "/" + o.apiVersion + "/"
 	}
 	e, err := url.Parse(endpoint)
 	if err != nil {
 		return nil, fmt.Errorf("invalid iexcloud endpoint: %s", err)
 	}
+	return e, nil
+}
+
+// token returns the command line API token, read from the token file if set
+func (o iexcloudOpts) token() (string, error) {
+	switch {
+	case o.apiTokenFile != "" && o.apiToken != "":
+		return "", errors.New("--iexcloud.api_token and --iexcloud.api_token-file are mutually exclusive")
+	case o.apiTokenFile != "":
+		token, err := config.ReadTokenFile(o.apiTokenFile)
+		if err != nil {
+			return "", fmt.Errorf("Error reading API token: %s", err)
+		}
+		return token, nil
+	case o.apiToken == "":
+		return "", errors.New("an API token is required, set --iexcloud.api_token-file or IEXCLOUD_API_TOKEN")
+	}
+	return o.apiToken, nil
+}
 
-	configFile, err := os.Open(opts.configPath)
+// loadConfig reads the config file and the API tokens, creates the metric
+// groups and checks the modules. The token names of the groups and modules are
+// replaced with the tokens, the command line API token is returned along with
+// the config.
+func loadConfig(opts iexcloudOpts) (config.Config, []poller.Group, string, error) {
+	token, err := opts.token()
 	if err != nil {
-		return nil, fmt.Errorf("Error opening config file: %s", err)
+		return config.Config{}, nil, "", err
 	}
-	level.Info(logger).Log("msg", "Reading the config file", "config", opts.configPath)
+	data, err := ioutil.ReadFile(opts.configPath)
+	if err != nil {
+		return config.Config{}, nil, "", fmt.Errorf("Error reading config file: %s", err)
+	}
+	cfg, err := config.Parse(data)
+	if err != nil {
+		return config.Config{}, nil, "", fmt.Errorf("Error in config file: %s", err)
+	}
+	groups, err := newGroups(cfg, opts.refreshInterval)
+	if err != nil {
+		return config.Config{}, nil, "", fmt.Errorf("Error in config file: %s", err)
+	}
+	if err := checkModules(cfg.Modules); err != nil {
+		return config.Config{}, nil, "", fmt.Errorf("Error in config file: %s", err)
+	}
+	if cfg.Market != nil {
+		if err := calendar.Check(cfg.Market.Timezone, cfg.Market.Sessions); err != nil {
+			return config.Config{}, nil, "", fmt.Errorf("Error in config file: %s", config.WrapError("market", err))
+		}
+	}
+
+	tokens := map[string]string{"": token}
+	for name, t := range cfg.Tokens {
+		if tokens[name], err = t.Read(); err != nil {
+			return config.Config{}, nil, "", fmt.Errorf("Error reading API token %q: %s", name, err)
+		}
+	}
+	for i := range groups {
+		groups[i].Token = tokens[groups[i].Token]
+	}
+	for name, module := range cfg.Modules {
+		module.Token = tokens[module.Token]
+		cfg.Modules[name] = module
+	}
+	return cfg, groups, token, nil
+}
 
-	defer configFile.Close()
+// newGroups creates a configured collector for every metric group in the
+// config file
+func newGroups(cfg config.Config, interval time.Duration) ([]poller.Group, error) {
+	groups := make([]poller.Group, 0, len(cfg.Metrics))
+	for i, metric := range cfg.Metrics {
+		path := fmt.Sprintf("metrics[%d]", i)
+		c, err := model.New(metric.Name)
+		if err != nil {
+			return nil, config.WrapError(path, err)
+		}
+		if err := c.Configure(metric.Params); err != nil {
+			return nil, config.WrapError(path+"."+metric.Name, err)
+		}
+
+		// The token name is replaced with the token by loadConfig
+		group := poller.Group{Collector: c, Interval: interval, Token: metric.Token, Symbols: metric.Symbols()}
+		if global, local := cfg.Filter.Metrics, metric.Filter.Metrics; global != (config.Rule{}) || local != (config.Rule{}) {
+			group.Filter = func(name string) bool {
+				return global.Match(name) && local.Match(name)
+			}
+		}
+		if metric.Interval != nil {
+			group.Interval = time.Duration(*metric.Interval)
+		}
+		if metric.Schedule != "" {
+			location, err := metric.Location()
+			if err != nil {
+				return nil, config.WrapError(path+".timezone", err)
+			}
+			if group.Schedule, err = schedule.Parse(metric.Schedule, location); err != nil {
+				return nil, config.WrapError(path+".schedule", err)
+			}
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
 
-	config, err := ioutil.ReadAll(configFile)
+// NewExporter returns an initialized Exporter.
+func NewExporter(opts iexcloudOpts, logger log.Logger) (*Exporter, error) {
+	e, err := opts.endpointURL()
+	if err != nil {
+		return nil, err
+	}
+
+	level.Info(logger).Log("msg", "Reading the config file", "config", opts.configPath)
+	cfg, groups, token, err := loadConfig(opts)
 	if err != nil {
-		return nil, fmt.Errorf("Error reading config file: %s", err)
+		return nil, err
 	}
 
 	level.Info(logger).Log("msg", "initializing endpoint", "endpoint", e)
 
-	client := iex.NewClient(opts.apiToken, e.String())
+	base := http.DefaultTransport
+	switch {
+	case opts.recordDir != "" && opts.replayDir != "":
+		return nil, errors.New("cannot both record and replay the IEX Cloud responses")
+	case opts.recordDir != "":
+		level.Info(logger).Log("msg", "recording the IEX Cloud responses", "dir", opts.recordDir)
+		base = transport.NewRecorder(base, opts.recordDir)
+	case opts.replayDir != "":
+		fixtures, err := transport.LoadFixtures(opts.replayDir)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load the recorded responses: %s", err)
+		}
+		level.Info(logger).Log("msg", "replaying the recorded IEX Cloud responses", "dir", opts.replayDir, "count", len(fixtures))
+		base = transport.NewReplay(fixtures)
+	}
+
+	// Every attempt of a retried request goes through the limiter, the breaker
+	// only sees the outcome of the last attempt
+	limiter := transport.NewLimiter(transport.NewErrors(base), opts.rateLimit, opts.dailyBudget)
+	retry := transport.NewRetry(limiter, opts.maxRetries, opts.retryBackoff)
+	breaker := transport.NewBreaker(retry, opts.breakerFailures, opts.breakerCooldown, logger)
+	newClient := func(ctx context.Context, token string) *iex.Client {
+		httpClient := &http.Client{
+			Transport: transport.WithContext(ctx, breaker),
+			Timeout:   opts.timeout,
+		}
+		return iex.NewClient(token, e.String(), iex.WithHTTPClient(httpClient))
+	}
+
+	cal, err := calendar.New(calendar.DefaultTimezone, []string{"regular"}, logger)
+	if err != nil {
+		return nil, err
+	}
 
 	// Init our exporter.
-	return &Exporter{
-		Client:   client,
-		kvPrefix: kvPrefix,
-		kvFilter: regexp.MustCompile(kvFilter),
-		logger:   logger,
-		config:   config,
-	}, nil
+	exporter := &Exporter{
+		logger:               logger,
+		opts:                 opts,
+		transport:            []prometheus.Collector{limiter, retry, breaker},
+		newClient:            newClient,
+		calendar:             cal,
+		lastReloadSuccessful: true,
+		lastReloadSuccess:    time.Now(),
+	}
+	exporter.apply(cfg, groups, token)
+	return exporter, nil
 }
 
 func init() {
@@ -248,47 +525,95 @@ func main() {
 	var (
 		listenAddress = kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry.").Default(":9107").String()
 		metricsPath   = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
-		kvPrefix      = kingpin.Flag("kv.prefix", "Prefix from which to expose key/value pairs.").Default("").String()
-		kvFilter      = kingpin.Flag("kv.filter", "Regex that determines which keys to expose.").Default(".*").String()
+		timeoutOffset = kingpin.Flag("web.timeout-offset", "Offset to subtract from the timeout sent by Prometheus in X-Prometheus-Scrape-Timeout-Seconds.").Default("500ms").Duration()
 
 		opts = iexcloudOpts{}
+
+		fakeCmd = kingpin.Command("fake-iexcloud", "Serve a fake IEX Cloud API from fixtures, to run the exporter without a token nor network access.")
+		fake    = fakeOpts{}
+
+		backfillCmd = kingpin.Command("backfill", "Write the daily bars of symbols as OpenMetrics, to be imported with promtool tsdb create-blocks-from openmetrics.")
+		backfilled  = backfillOpts{}
 	)
 
-	kingpin.Flag("iexcloud.api_token", "API Token for IEX Cloud account").Required().StringVar(&opts.apiToken)
+	kingpin.Flag("iexcloud.api_token", "API Token for IEX Cloud account, prefer --iexcloud.api_token-file or IEXCLOUD_API_TOKEN").Envar("IEXCLOUD_API_TOKEN").StringVar(&opts.apiToken)
+	kingpin.Flag("iexcloud.api_token-file", "File containing the API Token for IEX Cloud account, read again on reload").StringVar(&opts.apiTokenFile)
 	kingpin.Flag("iexcloud.endpoint", "IEX Cloud API endpoint").Default("sandbox.iexapis.com").StringVar(&opts.endpoint)
 	kingpin.Flag("iexcloud.api_version", "IEX Cloud API version").Default("stable").StringVar(&opts.apiVersion)
+	kingpin.Flag("iexcloud.refresh-interval", "Default interval between two refreshes of a metric group, 0 refreshes on every scrape").Default("1m").DurationVar(&opts.refreshInterval)
+	kingpin.Flag("iexcloud.timeout", "Timeout of a refresh, and of a scrape if Prometheus does not send one").Default("30s").DurationVar(&opts.timeout)
+	kingpin.Flag("iexcloud.rate-limit", "Maximum number of IEX Cloud requests per second, 0 disables the limit").Default("10").Float64Var(&opts.rateLimit)
+	kingpin.Flag("iexcloud.daily-message-budget", "Maximum estimated number of IEX Cloud messages spent per day (UTC), 0 disables the limit").Default("0").Float64Var(&opts.dailyBudget)
+	kingpin.Flag("iexcloud.max-retries", "Maximum number of retries of a request failed with a 429 or 5xx status code").Default("3").IntVar(&opts.maxRetries)
+	kingpin.Flag("iexcloud.retry-backoff", "Initial backoff between two retries, doubled on every retry unless IEX Cloud sends Retry-After").Default("500ms").DurationVar(&opts.retryBackoff)
+	kingpin.Flag("iexcloud.breaker-failures", "Number of consecutive failed requests which pauses the IEX Cloud calls, 0 disables the circuit breaker").Default("5").IntVar(&opts.breakerFailures)
+	kingpin.Flag("iexcloud.breaker-cooldown", "Time after which a paused IEX Cloud is probed again").Default("1m").DurationVar(&opts.breakerCooldown)
+	kingpin.Flag("iexcloud.record-dir", "Directory in which the successful IEX Cloud responses are written as fixtures, without the token").StringVar(&opts.recordDir)
+	kingpin.Flag("iexcloud.replay-dir", "Directory of fixtures served instead of calling IEX Cloud, e.g. recorded with --iexcloud.record-dir").StringVar(&opts.replayDir)
 	pwd, _ := os.Getwd()
-	kingpin.Flag("iexcloud.config", "IEX Cloud API version").Default(pwd + "/config.json").StringVar(&opts.configPath)
+	kingpin.Flag("iexcloud.config", "Path of the config file, in YAML or JSON").Default(pwd + "/config.json").StringVar(&opts.configPath)
+
+	kingpin.Command("serve", "Run the exporter, the default command.").Default()
+	fakeCmd.Flag("listen-address", "Address on which to serve the fake API.").Default("127.0.0.1:9108").StringVar(&fake.listenAddress)
+	fakeCmd.Flag("fixtures", "Directory of JSON fixtures added to the built-in ones, the fixture of /stock/aapl/price is read from <dir>/stock/aapl/price.json.").StringVar(&fake.fixtures)
+	fakeCmd.Flag("fault", "Fault injected in the responses as [<path>=]<status|delay>[x<times>], e.g. /stock/msft=500, /status=2s or 429x3. Repeatable.").StringsVar(&fake.faults)
+
+	backfillCmd.Flag("symbols", "Symbols to backfill, as written in the config file. Repeatable or comma separated.").Required().StringsVar(&backfilled.symbols)
+	backfillCmd.Flag("range", "Range of the daily bars: 1m, 3m, 6m, ytd, 1y, 2y, 5y or max.").Default("1y").StringVar(&backfilled.timeRange)
+	backfillCmd.Flag("output", "File written, - for the standard output.").Default("-").StringVar(&backfilled.output)
 
 	promlogConfig := &promlog.Config{}
 	flag.AddFlags(kingpin.CommandLine, promlogConfig)
 	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
+	command := kingpin.Parse()
 	logger := promlog.New(promlogConfig)
 
+	if command == fakeCmd.FullCommand() {
+		if err := runFake(fake, logger); err != nil {
+			level.Error(logger).Log("msg", "error serving the fake IEX Cloud API", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == backfillCmd.FullCommand() {
+		if err := runBackfill(backfilled, opts, logger); err != nil {
+			level.Error(logger).Log("msg", "error backfilling", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	level.Info(logger).Log("msg", "starting iexcloud_exporter", "version", version.Info())
 	level.Info(logger).Log("build_context", version.BuildContext())
 
-	exporter, err := NewExporter(opts, *kvPrefix, *kvFilter, logger)
+	exporter, err := NewExporter(opts, logger)
 	if err != nil {
 		level.Error(logger).Log("msg", "error creating the exporter", "err", err)
 		os.Exit(1)
 	}
-	prometheus.MustRegister(exporter)
+	go exporter.Run(context.Background())
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := exporter.Reload(); err != nil {
+				level.Error(logger).Log("msg", "error reloading the config", "err", err)
+				continue
+			}
+			level.Info(logger).Log("msg", "config reloaded", "config", opts.configPath)
+		}
+	}()
 
 	http.Handle(*metricsPath,
 		promhttp.InstrumentMetricHandler(
 			prometheus.DefaultRegisterer,
-			promhttp.HandlerFor(
-				prometheus.DefaultGatherer,
-				promhttp.HandlerOpts{
-					ErrorLog: &promHTTPLogger{
-						logger: logger,
-					},
-				},
-			),
+			exporter.Handler(*timeoutOffset),
 		),
 	)
+	http.Handle("/probe", exporter.ProbeHandler(*timeoutOffset))
+	http.Handle("/-/reload", exporter.ReloadHandler())
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
              <head><title>Consul Exporter</title></head>
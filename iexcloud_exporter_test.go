@@ -25,9 +25,473 @@ SOFTWARE.
 package main
 
 import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/vglafirov/iexcloud_exporter/pkg/config"
+	"github.com/vglafirov/iexcloud_exporter/pkg/iextest"
+	"github.com/vglafirov/iexcloud_exporter/pkg/poller"
 )
 
+var update = flag.Bool("update", false, "update the golden files")
+
 func TestNewExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iexcloud_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+	opts := iexcloudOpts{endpoint: "sandbox.iexapis.com", apiVersion: "stable", configPath: path, apiToken: "test", timeout: time.Second}
+
+	if err := ioutil.WriteFile(path, []byte(`{"metrics": [{"dividends": {"symbols": ["aapl"], "range": ["7y"]}}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewExporter(opts, log.NewNopLogger())
+	if err == nil || !strings.Contains(err.Error(), `metrics[0].dividends.range[0]: invalid range "7y"`) {
+		t.Fatalf("expected the invalid range to be reported, got %v", err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"metrics": [{"dividends": {"symbols": ["aapl"], "range": ["5y"]}}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewExporter(opts, log.NewNopLogger()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewGroups(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+		err    bool
+		msg    string
+	}{
+		{
+			name:   "refresh settings",
+			config: `{"metrics": [{"price": {"symbols": ["aapl"]}, "interval": "15s"}, {"keystats": {"symbols": ["aapl"]}, "schedule": "30 16 * * 1-5", "timezone": "UTC"}]}`,
+			want:   []string{"price", "keystats"},
+		},
+		{
+			name:   "invalid schedule",
+			config: `{"metrics": [{"keystats": {"symbols": ["aapl"]}, "schedule": "30 25 * * *"}]}`,
+			err:    true,
+		},
+		{
+			name:   "registered groups",
+			config: `{"metrics": [{"price": {"symbols": ["aapl"]}}, {"keystats": {"symbols": ["aapl"]}}]}`,
+			want:   []string{"price", "keystats"},
+		},
+		{
+			name:   "unknown group",
+			config: `{"metrics": [{"prices": {"symbols": ["aapl"]}}]}`,
+			err:    true,
+		},
+		{
+			name:   "several groups in one entry",
+			config: `{"metrics": [{"price": {"symbols": ["aapl"]}, "keystats": {"symbols": ["aapl"]}}]}`,
+			err:    true,
+		},
+		{
+			name:   "invalid range",
+			config: `{"metrics": [{"price": {"symbols": ["aapl"]}}, {"dividends": {"symbols": ["aapl"], "range": ["1y", "7y"]}}]}`,
+			err:    true,
+			msg:    `metrics[1].dividends.range[1]: invalid range "7y"`,
+		},
+		{
+			name:   "unknown field",
+			config: `{"metrics": [{"price": {"symbol": ["aapl"]}}]}`,
+			err:    true,
+			msg:    `metrics[0].price: unknown field "symbol"`,
+		},
+		{
+			name:   "symbol of the wrong type",
+			config: `{"metrics": [{"keystats": {"symbols": ["aapl", 42]}}]}`,
+			err:    true,
+		},
+		{
+			name:   "empty symbol",
+			config: `{"metrics": [{"keystats": {"symbols": ["aapl", ""]}}]}`,
+			err:    true,
+			msg:    `metrics[0].keystats.symbols[1]: invalid symbol ""`,
+		},
+		{
+			name:   "no symbols",
+			config: `{"metrics": [{"price": {}}]}`,
+			err:    true,
+			msg:    `metrics[0].price.symbols: at least one symbol is required`,
+		},
+		{
+			name:   "invalid interval",
+			config: `{"metrics": [{"price": {"symbols": ["aapl"]}, "interval": "often"}]}`,
+			err:    true,
+		},
+		{
+			name:   "unknown top-level field",
+			config: `{"metric": [{"price": {"symbols": ["aapl"]}}]}`,
+			err:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := config.Parse([]byte(test.config))
+			var groups []poller.Group
+			if err == nil {
+				groups, err = newGroups(cfg, time.Minute)
+			}
+			if test.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if test.msg != "" && err.Error() != test.msg {
+					t.Fatalf("expected error %q, got %q", test.msg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(groups) != len(test.want) {
+				t.Fatalf("expected %d groups, got %d", len(test.want), len(groups))
+			}
+			for i, g := range groups {
+				if g.Collector.Name() != test.want[i] {
+					t.Errorf("group %d: expected %q, got %q", i, test.want[i], g.Collector.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iexcloud_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte("metrics:\n  - price:\n      symbols: [aapl]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := iexcloudOpts{endpoint: "sandbox.iexapis.com", apiVersion: "stable", configPath: path, apiToken: "test", timeout: time.Second}
+	e, err := NewExporter(opts, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := e.currentPoller()
+
+	if err := ioutil.WriteFile(path, []byte("metrics:\n  - price:\n      symbols: $watchlist\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	e.ReloadHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/-/reload", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected the invalid config to be rejected, got status %d", rec.Code)
+	}
+	if e.currentPoller() != p || e.lastReloadSuccessful {
+		t.Fatal("expected the running config to be kept")
+	}
+
+	rec = httptest.NewRecorder()
+	e.ReloadHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/reload", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected GET to be rejected, got status %d", rec.Code)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("symbol_sets:\n  watchlist: [aapl, msft]\nmetrics:\n  - price:\n      symbols: $watchlist\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	e.ReloadHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/-/reload", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the config to be reloaded, got status %d: %s", rec.Code, rec.Body)
+	}
+	if e.currentPoller() == p || !e.lastReloadSuccessful {
+		t.Fatal("expected the new config to be running")
+	}
+}
+
+func TestReloadRemovedSymbol(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iexcloud_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewServer(iextest.NewServer(iextest.DefaultFixtures()))
+	defer server.Close()
+
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte("metrics:\n  - price:\n      symbols: [aapl, msft, goog]\n    interval: 0s\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := iexcloudOpts{endpoint: server.URL + "/stable/", configPath: path, apiToken: "test", timeout: 5 * time.Second}
+	e, err := NewExporter(opts, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		e.Handler(0).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return rec.Body.String()
+	}
+	got := scrape()
+	for _, want := range []string{
+		`iexcloud_symbol_last_success_timestamp_seconds{collector="price",symbol="msft"}`,
+		`iexcloud_symbol_errors_total{collector="price",symbol="goog"} 1`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected %s, got:\n%s", want, got)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte("metrics:\n  - price:\n      symbols: [aapl]\n    interval: 0s\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	got = scrape()
+	if !strings.Contains(got, `iexcloud_symbol_last_success_timestamp_seconds{collector="price",symbol="aapl"}`) {
+		t.Fatalf("expected the last success of aapl to be kept, got:\n%s", got)
+	}
+	if strings.Contains(got, `symbol="msft"`) || strings.Contains(got, `symbol="goog"`) {
+		t.Fatalf("expected the metrics of the removed symbols to be dropped, got:\n%s", got)
+	}
+}
+
+func TestLoadConfigTokens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iexcloud_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	fundamentals := write("fundamentals", "sk_fundamentals\n")
+	opts := iexcloudOpts{
+		apiTokenFile: write("token", "sk_prices\n"),
+		configPath: write("config.yml", "tokens:\n  fundamentals:\n    file: "+fundamentals+"\n"+
+			"metrics:\n  - price:\n      symbols: [aapl]\n  - keystats:\n      symbols: [aapl]\n    token: fundamentals\n"+
+			"modules:\n  price:\n    price: {}\n  stats:\n    keystats: {}\n    token: fundamentals\n"),
+	}
+
+	cfg, groups, token, err := loadConfig(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "sk_prices" || groups[0].Token != "sk_prices" || groups[1].Token != "sk_fundamentals" {
+		t.Fatalf("unexpected tokens %q, %q and %q", token, groups[0].Token, groups[1].Token)
+	}
+	if cfg.Modules["price"].Token != "sk_prices" || cfg.Modules["stats"].Token != "sk_fundamentals" {
+		t.Fatalf("unexpected module tokens %q and %q", cfg.Modules["price"].Token, cfg.Modules["stats"].Token)
+	}
+
+	write("token", "sk_rotated")
+	if _, _, token, err = loadConfig(opts); err != nil {
+		t.Fatal(err)
+	}
+	if token != "sk_rotated" {
+		t.Fatalf("expected the token file to be read again, got %q", token)
+	}
+
+	opts.apiToken = "sk_flag"
+	if _, _, _, err := loadConfig(opts); err == nil {
+		t.Fatal("expected the token flag and file to be mutually exclusive")
+	}
+	opts.apiToken, opts.apiTokenFile = "", ""
+	if _, _, _, err := loadConfig(opts); err == nil {
+		t.Fatal("expected a missing token to be rejected")
+	}
+}
+
+// metricsConfig refreshes every group of the fake API on every scrape
+const metricsConfig = `
+defaults:
+  interval: 0s
+metrics:
+  - price:
+      symbols: [aapl, msft]
+  - quote:
+      symbols: [aapl, msft]
+  - ohlc:
+      symbols: [aapl, msft]
+  - previous:
+      symbols: [aapl, msft]
+  - intraday:
+      symbols: [aapl, msft]
+  - book:
+      symbols: [aapl, msft]
+  - keystats:
+      symbols: [aapl, msft]
+  - dividends:
+      symbols: [aapl, msft]
+      range: [1y]
+  - account: {}
+  - status: {}
+`
+
+// stableMetrics returns the iexcloud metrics of a scrape, leaving out the
+// timestamps, durations and build info which vary from run to run
+func stableMetrics(body string) string {
+	var lines []string
+	for _, line := range strings.Split(body, "\n") {
+		name := strings.TrimPrefix(strings.TrimPrefix(line, "# HELP "), "# TYPE ")
+		if !strings.HasPrefix(name, "iexcloud_") ||
+			strings.HasPrefix(name, "iexcloud_exporter_") ||
+			strings.Contains(name, "timestamp_seconds") ||
+			strings.Contains(name, "duration_seconds") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// scrapeMetrics scrapes an exporter running the metrics config
+func scrapeMetrics(t *testing.T, opts iexcloudOpts) string {
+	t.Helper()
+	e, err := NewExporter(opts, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	e.Handler(0).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body)
+	}
+	return stableMetrics(rec.Body.String())
+}
+
+func TestMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iexcloud_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewServer(iextest.NewServer(iextest.DefaultFixtures()))
+	defer server.Close()
+
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte(metricsConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := iexcloudOpts{endpoint: server.URL + "/stable/", configPath: path, apiToken: "test", timeout: 5 * time.Second}
+	got := scrapeMetrics(t, opts)
+
+	golden := filepath.Join("testdata", "metrics.golden")
+	if *update {
+		if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("unexpected metrics, run go test -update to review the changes:\n%s", got)
+	}
+
+	// The recorded responses are replayed without calling the network
+	opts.recordDir = filepath.Join(dir, "fixtures")
+	if got := scrapeMetrics(t, opts); got != string(want) {
+		t.Errorf("unexpected metrics while recording:\n%s", got)
+	}
+	// The usage of the account token is recorded under a placeholder
+	opts.endpoint, opts.recordDir, opts.replayDir = "sandbox.iexapis.com", "", opts.recordDir
+	replayed := strings.Replace(string(want), `token="pk_...cdef"`, `token="pk_...TED1"`, 1)
+	if got := scrapeMetrics(t, opts); got != replayed {
+		t.Errorf("unexpected replayed metrics:\n%s", got)
+	}
+
+	opts.recordDir = opts.replayDir
+	if _, err := NewExporter(opts, log.NewNopLogger()); err == nil {
+		t.Error("expected recording and replaying to be mutually exclusive")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iexcloud_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewServer(iextest.NewServer(iextest.DefaultFixtures()))
+	defer server.Close()
+
+	path := filepath.Join(dir, "config.yml")
+	config := `
+filter:
+  metrics:
+    deny: iexcloud_account_(daily|token|key)_usage_messages
+  symbols:
+    deny: msft
+defaults:
+  interval: 0s
+metrics:
+  - price:
+      symbols: [aapl, msft]
+  - keystats:
+      symbols: [aapl, msft]
+    filter:
+      metrics:
+        allow: iexcloud_keystats_(beta|peRatio)
+  - account: {}
+modules:
+  price:
+    price: {}
+`
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := iexcloudOpts{endpoint: server.URL + "/stable/", configPath: path, apiToken: "test", timeout: 5 * time.Second}
+	got := scrapeMetrics(t, opts)
+
+	for _, want := range []string{
+		`iexcloud_price{symbol="aapl"} 261.78`,
+		`iexcloud_keystats_beta{symbol="aapl"} 1.13`,
+		`iexcloud_keystats_peRatio{symbol="aapl"} 22.02`,
+		`iexcloud_account_messages_used 123456`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("expected %s in:\n%s", want, got)
+		}
+	}
+	for _, unwanted := range []string{`symbol="msft"`, "iexcloud_keystats_week52high", "iexcloud_account_daily_usage_messages"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("expected %s to be filtered out:\n%s", unwanted, got)
+		}
+	}
 
+	e, err := NewExporter(opts, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	e.ProbeHandler(0).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/probe?module=price&symbol=MSFT&symbol=msft", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected the filtered out symbols to be rejected, got status %d", rec.Code)
+	}
 }
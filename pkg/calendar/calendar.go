@@ -0,0 +1,355 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package calendar implements the US market calendar used to pause the
+// real-time metric groups while the market is closed.
+//
+// Trading days are weekdays which are not exchange holidays. The holidays are
+// learnt from the IEX Cloud trading calendar: the upcoming holidays and the
+// weekdays skipped between the previous and the next trading day. Until the
+// calendar has been fetched every weekday is a trading day. The trading days
+// before Independence Day and Christmas, and after Thanksgiving, are half
+// days: the regular session closes at 13:00 and the after-hours one at 17:00.
+package calendar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	// TODO: Replace with github.com/goinvest/iexcloud once https://github.com/goinvest/iexcloud/issues/41 is closed
+	iex "github.com/vglafirov/iexcloud"
+	"github.com/vglafirov/iexcloud_exporter/pkg/config"
+	"github.com/vglafirov/iexcloud_exporter/pkg/transport"
+)
+
+// Session is a trading session, as offsets from midnight in the exchange
+// timezone
+type Session struct {
+	Open, Close time.Duration
+}
+
+// Sessions trading sessions which can be enabled in the config file
+var Sessions = map[string]Session{
+	"premarket":  {Open: 4 * time.Hour, Close: 9*time.Hour + 30*time.Minute},
+	"regular":    {Open: 9*time.Hour + 30*time.Minute, Close: 16 * time.Hour},
+	"afterhours": {Open: 16 * time.Hour, Close: 20 * time.Hour},
+}
+
+// HalfDaySessions trading sessions of the half days, closing early
+var HalfDaySessions = map[string]Session{
+	"premarket":  {Open: 4 * time.Hour, Close: 9*time.Hour + 30*time.Minute},
+	"regular":    {Open: 9*time.Hour + 30*time.Minute, Close: 13 * time.Hour},
+	"afterhours": {Open: 13 * time.Hour, Close: 17 * time.Hour},
+}
+
+// DefaultTimezone timezone of the US exchanges
+const DefaultTimezone = "America/New_York"
+
+// refreshInterval interval between two fetches of the trading calendar
+const refreshInterval = 6 * time.Hour
+
+// holidays number of upcoming holidays fetched
+const holidays = 10
+
+var (
+	marketOpen = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "market", "open"),
+		"Whether one of the configured market sessions is open.",
+		nil, nil,
+	)
+	nextOpen = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "market", "next_open_timestamp_seconds"),
+		"Unix time of the open of the current or next market session.",
+		nil, nil,
+	)
+	nextClose = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "market", "next_close_timestamp_seconds"),
+		"Unix time of the close of the current or next market session.",
+		nil, nil,
+	)
+)
+
+// Calendar tells whether the market is open. It is safe for concurrent use.
+type Calendar struct {
+	logger log.Logger
+
+	mtx      sync.RWMutex
+	location *time.Location
+	// windows are the configured sessions, adjacent sessions merged, of the
+	// full and the half days
+	windows, halfDay []Session
+	holidays         map[string]bool
+}
+
+// New returns a calendar for the named sessions evaluated in the timezone
+func New(timezone string, sessions []string, logger log.Logger) (*Calendar, error) {
+	c := &Calendar{logger: logger, holidays: make(map[string]bool)}
+	if err := c.Configure(timezone, sessions); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Configure replaces the timezone and the sessions of the calendar
+func (c *Calendar) Configure(timezone string, sessions []string) error {
+	location, err := parse(timezone, sessions)
+	if err != nil {
+		return err
+	}
+	c.mtx.Lock()
+	c.location = location
+	c.windows, c.halfDay = merge(sessions, Sessions), merge(sessions, HalfDaySessions)
+	c.mtx.Unlock()
+	return nil
+}
+
+// Check reports the errors Configure would return
+func Check(timezone string, sessions []string) error {
+	_, err := parse(timezone, sessions)
+	return err
+}
+
+func parse(timezone string, sessions []string) (*time.Location, error) {
+	if timezone == "" {
+		timezone = DefaultTimezone
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, config.WrapError("timezone", err)
+	}
+	if len(sessions) == 0 {
+		return nil, config.WrapError("sessions", fmt.Errorf("at least one session is required"))
+	}
+	for i, name := range sessions {
+		if _, ok := Sessions[name]; !ok {
+			return nil, config.WrapError(fmt.Sprintf("sessions[%d]", i), fmt.Errorf("unknown session %q", name))
+		}
+	}
+	return location, nil
+}
+
+// merge returns the hours of the named sessions, adjacent sessions merged. The
+// names must have been checked by parse.
+func merge(sessions []string, hours map[string]Session) []Session {
+	var windows []Session
+	for _, name := range sessions {
+		windows = append(windows, hours[name])
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Open < windows[j].Open })
+	merged := windows[:1]
+	for _, w := range windows[1:] {
+		last := &merged[len(merged)-1]
+		if w.Open <= last.Close {
+			if w.Close > last.Close {
+				last.Close = w.Close
+			}
+			continue
+		}
+		merged = append(merged, w)
+	}
+	return merged
+}
+
+// TradingDay reports whether the day of t is a trading day
+func (c *Calendar) TradingDay(t time.Time) bool {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.tradingDay(t.In(c.location))
+}
+
+func (c *Calendar) tradingDay(t time.Time) bool {
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return false
+	}
+	return !c.holidays[t.Format("2006-01-02")]
+}
+
+// halfDayOf reports whether the trading day of t closes early. IEX Cloud does
+// not list the half days, they are told by the holidays next to them: the
+// eves of Independence Day and Christmas, and the day after Thanksgiving. The
+// eve of a holiday observed on another day is a full day.
+func (c *Calendar) halfDayOf(t time.Time) bool {
+	switch {
+	case t.Month() == time.July && t.Day() == 3, t.Month() == time.December && t.Day() == 24:
+		return c.holidays[t.AddDate(0, 0, 1).Format("2006-01-02")]
+	case t.Month() == time.November && t.Weekday() == time.Friday:
+		return c.holidays[t.AddDate(0, 0, -1).Format("2006-01-02")]
+	}
+	return false
+}
+
+// Open reports whether one of the sessions is open at t
+func (c *Calendar) Open(t time.Time) bool {
+	open, _ := c.Next(t)
+	return !open.IsZero() && !open.After(t)
+}
+
+// Next returns the open and the close of the session open at t, or of the
+// next session if none is open. Zero times are returned if there is no
+// trading day in the next 30 days.
+func (c *Calendar) Next(t time.Time) (open, close time.Time) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	t = t.In(c.location)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, c.location)
+	// Trading days are at most a couple of weeks apart
+	for i := 0; i < 30; i++ {
+		if c.tradingDay(day) {
+			windows := c.windows
+			if c.halfDayOf(day) {
+				windows = c.halfDay
+			}
+			for _, w := range windows {
+				open, close = at(day, w.Open), at(day, w.Close)
+				if t.Before(close) {
+					return open, close
+				}
+			}
+		}
+		day = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, c.location)
+	}
+	return time.Time{}, time.Time{}
+}
+
+// at returns the wall clock time of the day at the given offset from
+// midnight, so that sessions keep their local hours across daylight saving
+// time changes
+func at(day time.Time, offset time.Duration) time.Time {
+	hour, minute := int(offset/time.Hour), int(offset%time.Hour/time.Minute)
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, day.Location())
+}
+
+// Run fetches the trading calendar now and then every few hours until ctx is
+// cancelled. Failures are logged and the known holidays kept.
+func (c *Calendar) Run(ctx context.Context, client func(ctx context.Context) *iex.Client) {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for {
+		if err := c.Refresh(ctx, client(ctx)); err != nil {
+			level.Error(c.logger).Log("msg", "cannot fetch the trading calendar", "err", transport.Redact(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh fetches the upcoming holidays and the previous and next trading
+// days. The weekdays between the previous and the next trading day, other than
+// today, are holidays as well.
+func (c *Calendar) Refresh(ctx context.Context, client *iex.Client) error {
+	upcoming, err := tradeDates(client, fmt.Sprintf("/ref-data/us/dates/holiday/next/%d", holidays))
+	if err != nil {
+		return fmt.Errorf("cannot fetch the next holidays: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	previous, err := tradeDates(client, "/ref-data/us/dates/trade/last/1")
+	if err != nil {
+		return fmt.Errorf("cannot fetch the previous trading day: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	next, err := tradeDates(client, "/ref-data/us/dates/trade/next/1")
+	if err != nil {
+		return fmt.Errorf("cannot fetch the next trading day: %w", err)
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, d := range upcoming {
+		c.holidays[dateKey(d.Date)] = true
+	}
+	if len(previous) > 0 && len(next) > 0 {
+		today := time.Now().In(c.location).Format("2006-01-02")
+		from, to := time.Time(previous[0].Date), time.Time(next[0].Date)
+		for day := from.AddDate(0, 0, 1); day.Before(to); day = day.AddDate(0, 0, 1) {
+			key := day.Format("2006-01-02")
+			if key != today && day.Weekday() != time.Saturday && day.Weekday() != time.Sunday {
+				c.holidays[key] = true
+			}
+		}
+	}
+	level.Debug(c.logger).Log("msg", "trading calendar fetched", "holidays", len(c.holidays))
+	return nil
+}
+
+func dateKey(d iex.Date) string {
+	return time.Time(d).Format("2006-01-02")
+}
+
+// tradeDates fetches a trading calendar endpoint. These endpoints return a
+// list of dates while NextTradingDay, PreviousTradingDay, NextHoliday and
+// NextHolidays of the vendored client decode a single date, so both are
+// accepted.
+func tradeDates(client *iex.Client, endpoint string) ([]iex.TradeHolidayDate, error) {
+	var raw json.RawMessage
+	if err := client.GetJSON(endpoint, &raw); err != nil {
+		return nil, err
+	}
+	var dates []iex.TradeHolidayDate
+	if err := json.Unmarshal(raw, &dates); err == nil {
+		return dates, nil
+	}
+	var date iex.TradeHolidayDate
+	if err := json.Unmarshal(raw, &date); err != nil {
+		return nil, err
+	}
+	return []iex.TradeHolidayDate{date}, nil
+}
+
+// Describe implements prometheus.Collector.
+func (c *Calendar) Describe(ch chan<- *prometheus.Desc) {
+	ch <- marketOpen
+	ch <- nextOpen
+	ch <- nextClose
+}
+
+// Collect implements prometheus.Collector.
+func (c *Calendar) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	open, close := c.Next(now)
+	value := 0.0
+	if c.Open(now) {
+		value = 1
+	}
+	ch <- prometheus.MustNewConstMetric(marketOpen, prometheus.GaugeValue, value)
+	if open.IsZero() {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(nextOpen, prometheus.GaugeValue, float64(open.Unix()))
+	ch <- prometheus.MustNewConstMetric(nextClose, prometheus.GaugeValue, float64(close.Unix()))
+}
@@ -0,0 +1,189 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package calendar
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	iex "github.com/vglafirov/iexcloud"
+)
+
+func newYork(t *testing.T) *time.Location {
+	location, err := time.LoadLocation(DefaultTimezone)
+	if err != nil {
+		t.Skip(err)
+	}
+	return location
+}
+
+func TestOpen(t *testing.T) {
+	ny := newYork(t)
+	c, err := New("", []string{"regular", "premarket"}, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.holidays["2019-11-28"] = true
+
+	tests := []struct {
+		time              time.Time
+		open              bool
+		nextOpen, nextEnd time.Time
+	}{
+		// Wednesday, before the pre-market
+		{time.Date(2019, 11, 27, 3, 0, 0, 0, ny), false, time.Date(2019, 11, 27, 4, 0, 0, 0, ny), time.Date(2019, 11, 27, 16, 0, 0, 0, ny)},
+		// Wednesday, pre-market and regular sessions merged
+		{time.Date(2019, 11, 27, 9, 45, 0, 0, ny), true, time.Date(2019, 11, 27, 4, 0, 0, 0, ny), time.Date(2019, 11, 27, 16, 0, 0, 0, ny)},
+		// Wednesday after the close, Thanksgiving is skipped and the Friday
+		// closes early
+		{time.Date(2019, 11, 27, 16, 0, 0, 0, ny), false, time.Date(2019, 11, 29, 4, 0, 0, 0, ny), time.Date(2019, 11, 29, 13, 0, 0, 0, ny)},
+		// Saturday
+		{time.Date(2019, 11, 30, 12, 0, 0, 0, ny), false, time.Date(2019, 12, 2, 4, 0, 0, 0, ny), time.Date(2019, 12, 2, 16, 0, 0, 0, ny)},
+		// Monday after the switch to daylight saving time, in UTC
+		{time.Date(2019, 3, 11, 13, 35, 0, 0, time.UTC), true, time.Date(2019, 3, 11, 4, 0, 0, 0, ny), time.Date(2019, 3, 11, 16, 0, 0, 0, ny)},
+	}
+	for _, test := range tests {
+		if open := c.Open(test.time); open != test.open {
+			t.Errorf("%s: expected open %t, got %t", test.time, test.open, open)
+		}
+		open, close := c.Next(test.time)
+		if !open.Equal(test.nextOpen) || !close.Equal(test.nextEnd) {
+			t.Errorf("%s: expected the session %s - %s, got %s - %s", test.time, test.nextOpen, test.nextEnd, open, close)
+		}
+	}
+}
+
+func TestHalfDay(t *testing.T) {
+	ny := newYork(t)
+	c, err := New("", []string{"regular", "afterhours"}, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, day := range []string{"2019-07-04", "2019-11-28", "2019-12-25", "2020-07-03", "2021-12-24"} {
+		c.holidays[day] = true
+	}
+
+	tests := []struct {
+		time              time.Time
+		nextOpen, nextEnd time.Time
+	}{
+		// Eve of Independence Day
+		{time.Date(2019, 7, 3, 12, 0, 0, 0, ny), time.Date(2019, 7, 3, 9, 30, 0, 0, ny), time.Date(2019, 7, 3, 17, 0, 0, 0, ny)},
+		// Day after Thanksgiving, after the after-hours session
+		{time.Date(2019, 11, 29, 17, 0, 0, 0, ny), time.Date(2019, 12, 2, 9, 30, 0, 0, ny), time.Date(2019, 12, 2, 20, 0, 0, 0, ny)},
+		// Christmas Eve
+		{time.Date(2019, 12, 24, 8, 0, 0, 0, ny), time.Date(2019, 12, 24, 9, 30, 0, 0, ny), time.Date(2019, 12, 24, 17, 0, 0, 0, ny)},
+		// Independence Day observed on the Friday, the Thursday is a full day
+		{time.Date(2020, 7, 2, 12, 0, 0, 0, ny), time.Date(2020, 7, 2, 9, 30, 0, 0, ny), time.Date(2020, 7, 2, 20, 0, 0, 0, ny)},
+		// Christmas observed on Christmas Eve, the Thursday is a full day
+		{time.Date(2021, 12, 23, 12, 0, 0, 0, ny), time.Date(2021, 12, 23, 9, 30, 0, 0, ny), time.Date(2021, 12, 23, 20, 0, 0, 0, ny)},
+	}
+	for _, test := range tests {
+		open, close := c.Next(test.time)
+		if !open.Equal(test.nextOpen) || !close.Equal(test.nextEnd) {
+			t.Errorf("%s: expected the session %s - %s, got %s - %s", test.time, test.nextOpen, test.nextEnd, open, close)
+		}
+	}
+
+	if err := c.Configure("", []string{"regular"}); err != nil {
+		t.Fatal(err)
+	}
+	if open, close := c.Next(time.Date(2019, 11, 29, 12, 0, 0, 0, ny)); !close.Equal(time.Date(2019, 11, 29, 13, 0, 0, 0, ny)) {
+		t.Errorf("expected the regular session to close at 13:00 after Thanksgiving, got %s - %s", open, close)
+	}
+}
+
+func TestOpenWithoutTradingDay(t *testing.T) {
+	c, err := New("", []string{"regular"}, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2019, 11, 27, 12, 0, 0, 0, newYork(t))
+	for day := now; day.Before(now.AddDate(0, 0, 31)); day = day.AddDate(0, 0, 1) {
+		c.holidays[day.Format("2006-01-02")] = true
+	}
+
+	if open, _ := c.Next(now); !open.IsZero() {
+		t.Fatalf("expected no upcoming session, got %s", open)
+	}
+	if c.Open(now) {
+		t.Fatal("expected the market to be closed without upcoming session")
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	if err := Check("", []string{"regular", "overnight"}); err == nil || err.Error() != `sessions[1]: unknown session "overnight"` {
+		t.Fatalf("expected the unknown session to be reported, got %v", err)
+	}
+	if err := Check("Mars/Olympus_Mons", []string{"regular"}); err == nil {
+		t.Fatal("expected the unknown timezone to be reported")
+	}
+	if err := Check("", nil); err == nil {
+		t.Fatal("expected missing sessions to be reported")
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	ny := newYork(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case fmt.Sprintf("/ref-data/us/dates/holiday/next/%d", holidays):
+			fmt.Fprint(w, `[{"date": "2019-12-25", "settlementDate": ""}, {"date": "2020-01-01", "settlementDate": ""}]`)
+		case "/ref-data/us/dates/trade/last/1":
+			fmt.Fprint(w, `[{"date": "2019-11-27", "settlementDate": "2019-12-02"}]`)
+		case "/ref-data/us/dates/trade/next/1":
+			// A single date is accepted as well
+			fmt.Fprint(w, `{"date": "2019-12-02", "settlementDate": "2019-12-04"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	c, err := New("", []string{"regular"}, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Refresh(context.Background(), iex.NewClient("test", server.URL)); err != nil {
+		t.Fatal(err)
+	}
+	for _, day := range []time.Time{
+		time.Date(2019, 11, 28, 12, 0, 0, 0, ny),
+		time.Date(2019, 12, 25, 12, 0, 0, 0, ny),
+		time.Date(2020, 1, 1, 12, 0, 0, 0, ny),
+	} {
+		if c.TradingDay(day) {
+			t.Errorf("expected %s to be a holiday", day.Format("2006-01-02"))
+		}
+	}
+	if !c.TradingDay(time.Date(2019, 12, 2, 12, 0, 0, 0, ny)) {
+		t.Error("expected 2019-12-02 to be a trading day")
+	}
+}
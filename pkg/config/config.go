@@ -24,6 +24,21 @@ SOFTWARE.
 
 package config
 
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
 const (
 	// Namespace Prometheus namespace
 	Namespace = "iexcloud"
@@ -31,8 +46,518 @@ const (
 
 // Config exporter configuration type
 type Config struct {
-	Metrics []Metric `json:"metrics"`
+	// SymbolSets named symbol lists the metric groups can reference as
+	// $name
+	SymbolSets map[string][]string `json:"symbol_sets,omitempty"`
+	// Tokens named API tokens the metric groups can be billed to
+	Tokens map[string]Token `json:"tokens,omitempty"`
+	// Defaults refresh settings inherited by the metric groups
+	Defaults Defaults `json:"defaults,omitempty"`
+	Metrics  []Metric `json:"metrics"`
+	// Modules metric groups probed on /probe by name
+	Modules map[string]Module `json:"modules,omitempty"`
+	// Market pauses the real-time metric groups while the market is closed
+	// when set
+	Market *Market `json:"market,omitempty"`
+	// Filter applies to every metric group and module
+	Filter Filter `json:"filter,omitempty"`
+}
+
+// Market trading sessions during which the real-time metric groups are
+// refreshed
+type Market struct {
+	// Timezone of the exchange, America/New_York by default
+	Timezone string `json:"timezone,omitempty"`
+	// Sessions names of the sessions: premarket, regular or afterhours
+	Sessions []string `json:"sessions"`
+}
+
+// Defaults are the refresh settings of the metric groups which set none
+type Defaults struct {
+	Interval *Duration `json:"interval,omitempty"`
+	Schedule string    `json:"schedule,omitempty"`
+	Timezone string    `json:"timezone,omitempty"`
+	Token    string    `json:"token,omitempty"`
+}
+
+// Token is an API token read from a file or from an environment variable, so
+// that it does not appear in the config file
+type Token struct {
+	File string `json:"file,omitempty"`
+	Env  string `json:"env,omitempty"`
+}
+
+// Read returns the token. The file is read on every call so that the token
+// can be rotated.
+func (t Token) Read() (string, error) {
+	if t.File != "" {
+		return ReadTokenFile(t.File)
+	}
+	token := strings.TrimSpace(os.Getenv(t.Env))
+	if token == "" {
+		return "", fmt.Errorf("environment variable %s is empty", t.Env)
+	}
+	return token, nil
+}
+
+// ReadTokenFile returns the API token stored in the file
+func ReadTokenFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// Parse decodes the config file, in YAML or JSON. Unknown fields are rejected
+// and errors carry the path of the offending value. Symbol set references are
+// expanded and the defaults are applied to the metric groups.
+func Parse(data []byte) (Config, error) {
+	data, err := toJSON(data)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var raw struct {
+		SymbolSets map[string][]string        `json:"symbol_sets"`
+		Tokens     map[string]Token           `json:"tokens"`
+		Defaults   Defaults                   `json:"defaults"`
+		Metrics    []json.RawMessage          `json:"metrics"`
+		Modules    map[string]json.RawMessage `json:"modules"`
+		Market     *Market                    `json:"market"`
+		Filter     json.RawMessage            `json:"filter"`
+	}
+	if err := Decode(data, &raw); err != nil {
+		return Config{}, err
+	}
+	for name, symbols := range raw.SymbolSets {
+		if err := validateSymbols(symbols); err != nil {
+			return Config{}, WrapError("symbol_sets."+name, err)
+		}
+	}
+	for name, token := range raw.Tokens {
+		if (token.File == "") == (token.Env == "") {
+			return Config{}, WrapError("tokens."+name, errors.New("exactly one of file and env is required"))
+		}
+	}
+	if _, ok := raw.Tokens[raw.Defaults.Token]; raw.Defaults.Token != "" && !ok {
+		return Config{}, WrapError("defaults.token", fmt.Errorf("unknown token %q", raw.Defaults.Token))
+	}
+	if raw.Defaults.Interval != nil && *raw.Defaults.Interval < 0 {
+		return Config{}, WrapError("defaults.interval", fmt.Errorf("negative interval %s", time.Duration(*raw.Defaults.Interval)))
+	}
+	if len(raw.Metrics) == 0 && len(raw.Modules) == 0 {
+		return Config{}, WrapError("metrics", errors.New("at least one metric group or module is required"))
+	}
+
+	cfg := Config{
+		SymbolSets: raw.SymbolSets,
+		Tokens:     raw.Tokens,
+		Defaults:   raw.Defaults,
+		Metrics:    make([]Metric, len(raw.Metrics)),
+		Modules:    make(map[string]Module, len(raw.Modules)),
+		Market:     raw.Market,
+	}
+	if raw.Filter != nil {
+		if err := json.Unmarshal(raw.Filter, &cfg.Filter); err != nil {
+			return Config{}, WrapError("filter", err)
+		}
+	}
+	for name, m := range raw.Modules {
+		var module Module
+		if err := json.Unmarshal(m, &module); err != nil {
+			return Config{}, WrapError("modules."+name, err)
+		}
+		if module.Token == "" {
+			module.Token = cfg.Defaults.Token
+		}
+		if _, ok := cfg.Tokens[module.Token]; module.Token != "" && !ok {
+			return Config{}, WrapError("modules."+name+".token", fmt.Errorf("unknown token %q", module.Token))
+		}
+		cfg.Modules[name] = module
+	}
+	for i, m := range raw.Metrics {
+		path := fmt.Sprintf("metrics[%d]", i)
+		metric := &cfg.Metrics[i]
+		if err := json.Unmarshal(m, metric); err != nil {
+			return Config{}, WrapError(path, err)
+		}
+		if metric.Params, err = expandSymbols(metric.Params, cfg.SymbolSets); err != nil {
+			return Config{}, WrapError(path+"."+metric.Name, err)
+		}
+		if metric.Params, err = filterSymbols(metric.Params, cfg.Filter.Symbols, metric.Filter.Symbols); err != nil {
+			return Config{}, WrapError(path+"."+metric.Name, err)
+		}
+		metric.inherit(cfg.Defaults)
+		if _, ok := cfg.Tokens[metric.Token]; metric.Token != "" && !ok {
+			return Config{}, WrapError(path+".token", fmt.Errorf("unknown token %q", metric.Token))
+		}
+	}
+	return cfg, nil
+}
+
+// toJSON converts a YAML document to JSON. JSON documents are returned as is.
+func toJSON(data []byte) ([]byte, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		return data, nil
+	}
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	v, err := jsonValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// jsonValue replaces the YAML maps, which may have non-string keys, with
+// JSON objects
+func jsonValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			s, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid key %v, keys should be strings", key)
+			}
+			value, err := jsonValue(value)
+			if err != nil {
+				return nil, WrapError(s, err)
+			}
+			object[s] = value
+		}
+		return object, nil
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for i, value := range v {
+			value, err := jsonValue(value)
+			if err != nil {
+				return nil, WrapError(fmt.Sprintf("[%d]", i), err)
+			}
+			array[i] = value
+		}
+		return array, nil
+	default:
+		return v, nil
+	}
+}
+
+// expandSymbols replaces the symbol set references in the symbols parameter
+// of a metric group, either the whole list (symbols: $watchlist) or some of
+// its entries (symbols: [$watchlist, tsla])
+func expandSymbols(params json.RawMessage, sets map[string][]string) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(params, &fields); err != nil {
+		// Reported by the metric group
+		return params, nil
+	}
+	value, ok := fields["symbols"]
+	if !ok {
+		return params, nil
+	}
+
+	var symbols []string
+	var ref string
+	if err := json.Unmarshal(value, &ref); err == nil {
+		if !strings.HasPrefix(ref, "$") {
+			return nil, WrapError("symbols", fmt.Errorf("expected a list of symbols or a symbol set reference like $watchlist, got %q", ref))
+		}
+		if symbols, err = symbolSet(ref, sets); err != nil {
+			return nil, WrapError("symbols", err)
+		}
+	} else {
+		var entries []json.RawMessage
+		if err := json.Unmarshal(value, &entries); err != nil {
+			// Reported by the metric group
+			return params, nil
+		}
+		for i, entry := range entries {
+			var symbol string
+			if err := json.Unmarshal(entry, &symbol); err != nil {
+				return params, nil
+			}
+			if !strings.HasPrefix(symbol, "$") {
+				symbols = append(symbols, symbol)
+				continue
+			}
+			set, err := symbolSet(symbol, sets)
+			if err != nil {
+				return nil, WrapError(fmt.Sprintf("symbols[%d]", i), err)
+			}
+			symbols = append(symbols, set...)
+		}
+	}
+
+	var err error
+	if fields["symbols"], err = json.Marshal(symbols); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+func symbolSet(ref string, sets map[string][]string) ([]string, error) {
+	set, ok := sets[strings.TrimPrefix(ref, "$")]
+	if !ok {
+		return nil, fmt.Errorf("unknown symbol set %q", ref)
+	}
+	return set, nil
+}
+
+// Decode decodes data into v, rejecting unknown fields and trailing data
+func Decode(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return errors.New(strings.TrimPrefix(err.Error(), "json: "))
+	}
+	if decoder.More() {
+		return errors.New("unexpected data after the top-level value")
+	}
+	return nil
+}
+
+// Error is an invalid value of the config file
+type Error struct {
+	// Path of the value, e.g. metrics[1].dividends.range[0]
+	Path string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Path + ": " + e.Err.Error()
 }
 
-// Metric generic metric type
-type Metric map[string]interface{}
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// WrapError returns err located at path. If err is already located, path is
+// prepended to its path.
+func WrapError(path string, err error) error {
+	var e *Error
+	if errors.As(err, &e) {
+		if strings.HasPrefix(e.Path, "[") {
+			return &Error{Path: path + e.Path, Err: e.Err}
+		}
+		return &Error{Path: path + "." + e.Path, Err: e.Err}
+	}
+	return &Error{Path: path, Err: err}
+}
+
+var symbolRegexp = regexp.MustCompile(`^[A-Za-z0-9.\-^=+#]+$`)
+
+// ValidateSymbols checks that at least one symbol is listed and that every
+// symbol can be passed to IEX Cloud
+func ValidateSymbols(symbols []string) error {
+	if err := validateSymbols(symbols); err != nil {
+		return WrapError("symbols", err)
+	}
+	return nil
+}
+
+func validateSymbols(symbols []string) error {
+	if len(symbols) == 0 {
+		return errors.New("at least one symbol is required")
+	}
+	for i, symbol := range symbols {
+		if !symbolRegexp.MatchString(symbol) {
+			return WrapError(fmt.Sprintf("[%d]", i), fmt.Errorf("invalid symbol %q", symbol))
+		}
+	}
+	return nil
+}
+
+// Metric is a metric group entry of the config file. Besides the metric group
+// itself an entry may set how often the group is refreshed.
+type Metric struct {
+	// Name of the metric group
+	Name string
+	// Params raw parameters of the metric group
+	Params json.RawMessage
+	// Interval between two refreshes, falls back to the command line default
+	// when unset
+	Interval *Duration `json:"interval,omitempty"`
+	// Schedule cron-like refresh schedule, replaces the interval
+	Schedule string `json:"schedule,omitempty"`
+	// Timezone in which the schedule is evaluated, local time by default
+	Timezone string `json:"timezone,omitempty"`
+	// Token name of the API token the group is billed to, the command line
+	// token when unset
+	Token string `json:"token,omitempty"`
+	// Filter applies to the group on top of the global filter
+	Filter Filter `json:"filter,omitempty"`
+}
+
+// UnmarshalJSON implements the Unmarshaler interface for Metric.
+func (m *Metric) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	var group []string
+	for key, value := range fields {
+		switch key {
+		case "interval":
+			m.Interval = new(Duration)
+			if err := json.Unmarshal(value, m.Interval); err != nil {
+				return WrapError(key, err)
+			}
+			if *m.Interval < 0 {
+				return WrapError(key, fmt.Errorf("negative interval %s", value))
+			}
+		case "schedule":
+			if err := json.Unmarshal(value, &m.Schedule); err != nil {
+				return WrapError(key, errors.New("schedule should be a string"))
+			}
+		case "timezone":
+			if err := json.Unmarshal(value, &m.Timezone); err != nil {
+				return WrapError(key, errors.New("timezone should be a string"))
+			}
+		case "token":
+			if err := json.Unmarshal(value, &m.Token); err != nil {
+				return WrapError(key, errors.New("token should be a string"))
+			}
+		case "filter":
+			if err := json.Unmarshal(value, &m.Filter); err != nil {
+				return WrapError(key, err)
+			}
+		default:
+			m.Name, m.Params = key, value
+			group = append(group, key)
+		}
+	}
+	if len(group) != 1 {
+		return fmt.Errorf("expected exactly one metric group, got %d", len(group))
+	}
+	return nil
+}
+
+// Module is a metric group probed for the symbols of the /probe request
+type Module struct {
+	// Name of the metric group
+	Name string
+	// Params raw parameters of the metric group, without the symbols
+	Params json.RawMessage
+	// Token name of the API token the probes are billed to, the command line
+	// token when unset
+	Token string `json:"token,omitempty"`
+}
+
+// UnmarshalJSON implements the Unmarshaler interface for Module.
+func (m *Module) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	var group []string
+	for key, value := range fields {
+		switch key {
+		case "token":
+			if err := json.Unmarshal(value, &m.Token); err != nil {
+				return WrapError(key, errors.New("token should be a string"))
+			}
+		default:
+			m.Name, m.Params = key, value
+			group = append(group, key)
+		}
+	}
+	if len(group) != 1 {
+		return fmt.Errorf("expected exactly one metric group, got %d", len(group))
+	}
+
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(m.Params, &params); err != nil {
+		return WrapError(m.Name, errors.New("parameters should be an object"))
+	}
+	if _, ok := params["symbols"]; ok {
+		return WrapError(m.Name+".symbols", errors.New("symbols are set by the probe request"))
+	}
+	return nil
+}
+
+// WithSymbols returns the parameters of the metric group for the symbols
+func (m Module) WithSymbols(symbols []string) (json.RawMessage, error) {
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(m.Params, &params); err != nil {
+		return nil, err
+	}
+	if params == nil {
+		params = make(map[string]json.RawMessage)
+	}
+	var err error
+	if params["symbols"], err = json.Marshal(symbols); err != nil {
+		return nil, err
+	}
+	return json.Marshal(params)
+}
+
+// inherit applies the defaults to the refresh settings the metric group does
+// not set. The interval and the schedule are only inherited if the group sets
+// neither.
+func (m *Metric) inherit(defaults Defaults) {
+	if m.Interval == nil && m.Schedule == "" {
+		m.Interval, m.Schedule = defaults.Interval, defaults.Schedule
+	}
+	if m.Timezone == "" {
+		m.Timezone = defaults.Timezone
+	}
+	if m.Token == "" {
+		m.Token = defaults.Token
+	}
+}
+
+// Location returns the location in which the schedule is evaluated
+func (m *Metric) Location() (*time.Location, error) {
+	if m.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(m.Timezone)
+}
+
+// Symbols returns the symbols parameter of the metric group, symbol sets
+// expanded, or nil if the group has none
+func (m *Metric) Symbols() []string {
+	var p struct {
+		Symbols []string `json:"symbols"`
+	}
+	// Invalid parameters are reported by the metric group
+	json.Unmarshal(m.Params, &p)
+	return p.Symbols
+}
+
+// Duration is a duration which accepts both Go durations (1h30m) and the
+// Prometheus units (1d, 1w, 1y) in the config file
+type Duration time.Duration
+
+// UnmarshalJSON implements the Unmarshaler interface for Duration.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration should be a string, got %s", data)
+	}
+	if v, err := time.ParseDuration(s); err == nil {
+		*d = Duration(v)
+		return nil
+	}
+	v, err := prommodel.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON implements the Marshaler interface for Duration.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(prommodel.Duration(d).String())
+}
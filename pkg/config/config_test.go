@@ -0,0 +1,184 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []Metric
+		err    string
+	}{
+		{
+			name: "yaml with symbol sets and defaults",
+			config: `
+symbol_sets:
+  watchlist: [msft, aapl]
+defaults:
+  interval: 5m
+  timezone: America/New_York
+metrics:
+  - price:
+      symbols: $watchlist
+    interval: 15s
+  - keystats:
+      symbols: [$watchlist, tsla]
+    schedule: "30 16 * * 1-5"
+    timezone: UTC
+  - dividends:
+      symbols: [ibm]
+      range: [1y]
+`,
+			want: []Metric{
+				{Name: "price", Params: []byte(`{"symbols":["msft","aapl"]}`), Interval: durationPtr(15 * time.Second), Timezone: "America/New_York"},
+				{Name: "keystats", Params: []byte(`{"symbols":["msft","aapl","tsla"]}`), Schedule: "30 16 * * 1-5", Timezone: "UTC"},
+				{Name: "dividends", Params: []byte(`{"range":["1y"],"symbols":["ibm"]}`), Interval: durationPtr(5 * time.Minute), Timezone: "America/New_York"},
+			},
+		},
+		{
+			name:   "json",
+			config: `{"symbol_sets": {"watchlist": ["aapl"]}, "metrics": [{"price": {"symbols": "$watchlist"}}]}`,
+			want: []Metric{
+				{Name: "price", Params: []byte(`{"symbols":["aapl"]}`)},
+			},
+		},
+		{
+			name:   "unknown symbol set",
+			config: "symbol_sets:\n  watchlist: [aapl]\nmetrics:\n  - price:\n      symbols: [aapl, $watchlst]\n",
+			err:    `metrics[0].price.symbols[1]: unknown symbol set "$watchlst"`,
+		},
+		{
+			name:   "symbols without reference",
+			config: "metrics:\n  - price:\n      symbols: aapl\n",
+			err:    `metrics[0].price.symbols: expected a list of symbols or a symbol set reference like $watchlist, got "aapl"`,
+		},
+		{
+			name:   "invalid symbol set",
+			config: "symbol_sets:\n  watchlist: [aapl, 'a b']\nmetrics:\n  - price:\n      symbols: $watchlist\n",
+			err:    `symbol_sets.watchlist[1]: invalid symbol "a b"`,
+		},
+		{
+			name:   "module with symbols",
+			config: "modules:\n  stats:\n    keystats:\n      symbols: [aapl]\n",
+			err:    `modules.stats.keystats.symbols: symbols are set by the probe request`,
+		},
+		{
+			name:   "tokens",
+			config: "tokens:\n  fundamentals:\n    env: IEX_FUNDAMENTALS_TOKEN\ndefaults:\n  token: fundamentals\nmetrics:\n  - price:\n      symbols: [aapl]\n",
+			want: []Metric{
+				{Name: "price", Params: []byte(`{"symbols":["aapl"]}`), Token: "fundamentals"},
+			},
+		},
+		{
+			name:   "unknown token",
+			config: "tokens:\n  fundamentals:\n    file: /etc/iexcloud/token\nmetrics:\n  - price:\n      symbols: [aapl]\n    token: fundamental\n",
+			err:    `metrics[0].token: unknown token "fundamental"`,
+		},
+		{
+			name:   "unknown module token",
+			config: "tokens:\n  fundamentals:\n    file: /etc/iexcloud/token\nmodules:\n  stats:\n    keystats: {}\n    token: fundamental\n",
+			err:    `modules.stats.token: unknown token "fundamental"`,
+		},
+		{
+			name:   "token without source",
+			config: "tokens:\n  fundamentals: {}\nmetrics:\n  - price:\n      symbols: [aapl]\n",
+			err:    `tokens.fundamentals: exactly one of file and env is required`,
+		},
+		{
+			name:   "unknown defaults field",
+			config: "defaults:\n  intervall: 1m\nmetrics:\n  - price:\n      symbols: [aapl]\n",
+			err:    `unknown field "intervall"`,
+		},
+		{
+			name:   "filtered symbols",
+			config: "filter:\n  symbols:\n    deny: tsla|goog\nmetrics:\n  - price:\n      symbols: [aapl, tsla]\n  - keystats:\n      symbols: [aapl, msft, goog]\n    filter:\n      symbols:\n        allow: 'm.*'\n",
+			want: []Metric{
+				{Name: "price", Params: []byte(`{"symbols":["aapl"]}`)},
+				{Name: "keystats", Params: []byte(`{"symbols":["msft"]}`)},
+			},
+		},
+		{
+			name:   "every symbol filtered out",
+			config: "metrics:\n  - price:\n      symbols: [aapl, tsla]\n    filter:\n      symbols:\n        deny: '.*'\n",
+			err:    `metrics[0].price.symbols: every symbol is filtered out`,
+		},
+		{
+			name:   "invalid global filter",
+			config: "filter:\n  metrics:\n    allow: 'iexcloud_('\nmetrics:\n  - price:\n      symbols: [aapl]\n",
+			err:    "filter.metrics.allow: invalid regular expression \"iexcloud_(\": error parsing regexp: missing closing ): `iexcloud_(`",
+		},
+		{
+			name:   "invalid group filter",
+			config: "metrics:\n  - price:\n      symbols: [aapl]\n    filter:\n      symbols:\n        deny: '[a-'\n",
+			err:    "metrics[0].filter.symbols.deny: invalid regular expression \"[a-\": error parsing regexp: missing closing ]: `[a-`",
+		},
+		{
+			name:   "unknown filter field",
+			config: "filter:\n  metric:\n    allow: iexcloud_price\nmetrics:\n  - price:\n      symbols: [aapl]\n",
+			err:    `filter: unknown field "metric"`,
+		},
+		{
+			name:   "no metrics",
+			config: "symbol_sets:\n  watchlist: [aapl]\n",
+			err:    `metrics: at least one metric group or module is required`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := Parse([]byte(test.config))
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cfg.Metrics) != len(test.want) {
+				t.Fatalf("expected %d metric groups, got %d", len(test.want), len(cfg.Metrics))
+			}
+			for i, want := range test.want {
+				got := cfg.Metrics[i]
+				if got.Name != want.Name || string(got.Params) != string(want.Params) ||
+					!reflect.DeepEqual(got.Interval, want.Interval) || got.Schedule != want.Schedule || got.Timezone != want.Timezone || got.Token != want.Token {
+					t.Errorf("metric group %d: expected %+v, got %+v", i, want, got)
+				}
+			}
+		})
+	}
+}
+
+func durationPtr(d time.Duration) *Duration {
+	v := Duration(d)
+	return &v
+}
@@ -0,0 +1,155 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// Filter keeps the metrics and the symbols matching its rules. The global
+// filter of the config file applies to every metric group and probe, the
+// filter of a metric group to the group only.
+type Filter struct {
+	// Metrics rule matched against the metric names
+	Metrics Rule
+	// Symbols rule matched case-insensitively against the symbols, the
+	// filtered out symbols are not requested
+	Symbols Rule
+}
+
+// Rule keeps the values fully matching Allow, all of them when unset, and not
+// fully matching Deny
+type Rule struct {
+	Allow *regexp.Regexp
+	Deny  *regexp.Regexp
+}
+
+// Match reports whether the rule keeps the value
+func (r Rule) Match(s string) bool {
+	if r.Allow != nil && !r.Allow.MatchString(s) {
+		return false
+	}
+	return r.Deny == nil || !r.Deny.MatchString(s)
+}
+
+// UnmarshalJSON implements the Unmarshaler interface for Filter.
+func (f *Filter) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Metrics rawRule `json:"metrics"`
+		Symbols rawRule `json:"symbols"`
+	}
+	if err := Decode(data, &raw); err != nil {
+		return err
+	}
+	var err error
+	if f.Metrics, err = newRule(raw.Metrics, ""); err != nil {
+		return WrapError("metrics", err)
+	}
+	if f.Symbols, err = newRule(raw.Symbols, "(?i)"); err != nil {
+		return WrapError("symbols", err)
+	}
+	return nil
+}
+
+// rawRule is a rule as written in the config file
+type rawRule struct {
+	Allow *string `json:"allow"`
+	Deny  *string `json:"deny"`
+}
+
+// newRule compiles the allow and deny regular expressions of a rule with the
+// given flags
+func newRule(raw rawRule, flags string) (Rule, error) {
+	var (
+		r   Rule
+		err error
+	)
+	if raw.Allow != nil {
+		if r.Allow, err = compileAnchored(*raw.Allow, flags); err != nil {
+			return r, WrapError("allow", err)
+		}
+	}
+	if raw.Deny != nil {
+		if r.Deny, err = compileAnchored(*raw.Deny, flags); err != nil {
+			return r, WrapError("deny", err)
+		}
+	}
+	return r, nil
+}
+
+// compileAnchored compiles a regular expression matching whole values only,
+// like the Prometheus relabeling rules
+func compileAnchored(expr, flags string) (*regexp.Regexp, error) {
+	if _, err := regexp.Compile(expr); err != nil {
+		return nil, fmt.Errorf("invalid regular expression %q: %s", expr, err)
+	}
+	return regexp.Compile(flags + "^(?:" + expr + ")$")
+}
+
+// filterSymbols removes the symbols the rules do not keep from the symbols
+// parameter of a metric group
+func filterSymbols(params json.RawMessage, rules ...Rule) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(params, &fields); err != nil {
+		// Reported by the metric group
+		return params, nil
+	}
+	var symbols []string
+	if err := json.Unmarshal(fields["symbols"], &symbols); err != nil || len(symbols) == 0 {
+		// Reported by the metric group
+		return params, nil
+	}
+
+	kept := FilterSymbols(symbols, rules...)
+	if len(kept) == 0 {
+		return nil, WrapError("symbols", errors.New("every symbol is filtered out"))
+	}
+	if len(kept) == len(symbols) {
+		return params, nil
+	}
+	var err error
+	if fields["symbols"], err = json.Marshal(kept); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// FilterSymbols returns the symbols kept by all the rules
+func FilterSymbols(symbols []string, rules ...Rule) []string {
+	kept := make([]string, 0, len(symbols))
+	for _, symbol := range symbols {
+		keep := true
+		for _, r := range rules {
+			keep = keep && r.Match(symbol)
+		}
+		if keep {
+			kept = append(kept, symbol)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,59 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package iextest
+
+import "github.com/vglafirov/iexcloud_exporter/pkg/transport"
+
+// DefaultFixtures returns the fixtures of the AAPL and MSFT symbols, the
+// account and the system status. The AAPL quote, OHLC and book are taken
+// during the trading day, the MSFT ones after the close, when the book is
+// empty.
+func DefaultFixtures() transport.Fixtures {
+	return transport.Fixtures{
+		"/status":           `{"status":"up","version":"beta","time":1573430400000}`,
+		"/account/metadata": `{"overagesEnabled":false,"effectiveDate":1572566400000,"endDateEffective":null,"subscriptionTermType":"monthly","tierName":"launch","messageLimit":5000000,"messagesUsed":123456}`,
+		"/account/usage":    `{"monthlyUsage":123456,"monthlyPayAsYouGo":0,"dailyUsage":{"20191109":100000,"20191110":23456},"tokenUsage":{"pk_0123456789abcdef":123456},"keyUsage":{"price":2456,"stats":121000}}`,
+
+		"/stock/aapl/price":        `261.78`,
+		"/stock/aapl/quote":        `{"symbol":"AAPL","companyName":"Apple, Inc.","calculationPrice":"tops","open":null,"openTime":null,"close":null,"closeTime":null,"high":262.49,"low":259.96,"latestPrice":261.78,"latestSource":"IEX real time price","latestTime":"11:52:03 AM","latestUpdate":1573491123456,"latestVolume":11836145,"iexRealtimePrice":261.78,"iexRealtimeSize":100,"iexLastUpdated":1573491123456,"delayedPrice":261.7,"delayedPriceTime":1573490223456,"extendedPrice":null,"extendedChange":null,"extendedChangePercent":null,"extendedPriceTime":null,"previousClose":260.14,"change":1.64,"changePercent":0.0063,"iexMarketPercent":0.0218,"iexVolume":258036,"avgTotalVolume":25383500,"iexBidPrice":261.75,"iexBidSize":100,"iexAskPrice":261.8,"iexAskSize":200,"marketCap":1163047150000,"week52High":262.49,"week52Low":142,"ytdChange":0.6595,"peRatio":22.02}`,
+		"/stock/aapl/ohlc":         `{"open":{"price":260.55,"time":1573482600112},"close":{"price":null,"time":null},"high":262.49,"low":259.96}`,
+		"/stock/aapl/previous":     `{"date":"2019-11-08","open":258.69,"close":260.14,"high":260.44,"low":256.85,"volume":17520495,"uOpen":258.69,"uClose":260.14,"uHigh":260.44,"uLow":256.85,"uVolume":17520495,"change":0.71,"changePercent":0.2737,"label":"Nov 8, 19","changeOverTime":0.002737,"symbol":"AAPL"}`,
+		"/stock/aapl/book":         `{"quote":{"symbol":"AAPL","calculationPrice":"tops","latestPrice":261.78,"latestSource":"IEX real time price","latestUpdate":1573491123456,"iexBidPrice":261.75,"iexBidSize":100,"iexAskPrice":261.8,"iexAskSize":200},"bids":[{"price":261.75,"size":100,"timestamp":1573491123001},{"price":261.7,"size":300,"timestamp":1573491122870},{"price":261.5,"size":500,"timestamp":1573491120150}],"asks":[{"price":261.8,"size":200,"timestamp":1573491123120},{"price":261.85,"size":100,"timestamp":1573491122990},{"price":262.1,"size":400,"timestamp":1573491110530}],"trades":[{"price":261.78,"size":100,"tradeId":517341294,"isISO":false,"isOddLot":false,"isOutsideRegularHours":false,"isSinglePriceCross":false,"isTradeThroughExempt":false,"timestamp":1573491123456}],"systemEvent":{"systemEvent":"R","timestamp":1573482600000}}`,
+		"/stock/aapl/chart/1d":     `[{"date":"2019-11-11","minute":"09:30","label":"09:30 AM","high":260.9,"low":260.55,"average":260.71,"volume":6150,"notional":1603366.5,"numberOfTrades":52,"marketHigh":260.95,"marketLow":260.5,"marketAverage":260.72,"marketVolume":318205,"marketNotional":82962806.6,"marketNumberOfTrades":1835,"open":260.55,"close":260.81,"marketOpen":260.55,"marketClose":260.8,"changeOverTime":0,"marketChangeOverTime":0},{"date":"2019-11-11","minute":"09:31","label":"09:31 AM","high":null,"low":null,"average":null,"volume":0,"notional":0,"numberOfTrades":0,"marketHigh":null,"marketLow":null,"marketAverage":null,"marketVolume":0,"marketNotional":0,"marketNumberOfTrades":0,"open":null,"close":null,"marketOpen":null,"marketClose":null,"changeOverTime":null,"marketChangeOverTime":null},{"date":"2019-11-11","minute":"09:32","label":"09:32 AM","high":261.02,"low":260.78,"average":260.9,"volume":2300,"notional":600070,"numberOfTrades":21,"marketHigh":261.05,"marketLow":260.75,"marketAverage":260.91,"marketVolume":120443,"marketNotional":31424784.1,"marketNumberOfTrades":903,"open":260.8,"close":261,"marketOpen":260.79,"marketClose":261.01,"changeOverTime":0.0007,"marketChangeOverTime":0.0008}]`,
+		"/stock/aapl/stats":        `{"companyName":"Apple, Inc.","marketCap":1163047150000,"week52High":262.49,"week52Low":142,"week52Change":0.330755,"sharesOutstanding":4443270000,"avg30Volume":25383500,"avg10Volume":22960350,"float":4438482000,"employees":137000,"ttmEPS":11.89,"ttmDividendRate":3.04,"dividendYield":0.0116,"nextDividendDate":"","exDividendDate":"2019-11-07","nextEarningsDate":"2020-01-28","peRatio":22.02,"beta":1.13,"day200MovingAvg":206.92,"day50MovingAvg":236.53,"maxChangePercent":319.12,"year5ChangePercent":1.3297,"year2ChangePercent":0.5114,"year1ChangePercent":0.3308,"ytdChangePercent":0.6595,"month6ChangePercent":0.3224,"month3ChangePercent":0.2588,"month1ChangePercent":0.1214,"day30ChangePercent":0.1214,"day5ChangePercent":0.0174}`,
+		"/stock/aapl/dividends/1y": `[{"exDate":"2019-11-07","paymentDate":"2019-11-14","recordDate":"2019-11-11","declaredDate":"2019-10-30","amount":"0.77","flag":"Cash"},{"exDate":"2019-08-09","paymentDate":"2019-08-15","recordDate":"2019-08-12","declaredDate":"2019-07-30","amount":"0.77","flag":"Cash"}]`,
+		"/stock/aapl/dividends/5y": `[{"exDate":"2019-11-07","paymentDate":"2019-11-14","recordDate":"2019-11-11","declaredDate":"2019-10-30","amount":"0.77","flag":"Cash"},{"exDate":"2019-08-09","paymentDate":"2019-08-15","recordDate":"2019-08-12","declaredDate":"2019-07-30","amount":"0.77","flag":"Cash"},{"exDate":"2015-02-05","paymentDate":"2015-02-12","recordDate":"2015-02-09","declaredDate":"2015-01-27","amount":"0.47","flag":"Cash"}]`,
+
+		"/stock/msft/price":        `149.97`,
+		"/stock/msft/quote":        `{"symbol":"MSFT","companyName":"Microsoft Corp.","calculationPrice":"close","open":147.48,"openTime":1573482600598,"close":149.97,"closeTime":1573506000404,"high":150.3,"low":147.2,"latestPrice":149.97,"latestSource":"Close","latestTime":"November 11, 2019","latestUpdate":1573506000404,"latestVolume":22784000,"iexRealtimePrice":null,"iexRealtimeSize":null,"iexLastUpdated":null,"delayedPrice":149.97,"delayedPriceTime":1573506000404,"extendedPrice":150.05,"extendedChange":0.08,"extendedChangePercent":0.00053,"extendedPriceTime":1573516800000,"previousClose":147.31,"change":2.66,"changePercent":0.01806,"iexMarketPercent":null,"iexVolume":null,"avgTotalVolume":22784000,"iexBidPrice":null,"iexBidSize":null,"iexAskPrice":null,"iexAskSize":null,"marketCap":1145310000000,"week52High":150.3,"week52Low":93.96,"ytdChange":0.4765,"peRatio":29.64}`,
+		"/stock/msft/ohlc":         `{"open":{"price":147.48,"time":1573482600598},"close":{"price":149.97,"time":1573506000404},"high":150.3,"low":147.2}`,
+		"/stock/msft/previous":     `{"date":"2019-11-08","open":143.98,"close":147.31,"high":147.37,"low":143.22,"volume":20079434,"uOpen":143.98,"uClose":147.31,"uHigh":147.37,"uLow":143.22,"uVolume":20079434,"change":3.69,"changePercent":2.5693,"label":"Nov 8, 19","changeOverTime":0.025693,"symbol":"MSFT"}`,
+		"/stock/msft/book":         `{"quote":{"symbol":"MSFT","calculationPrice":"close","latestPrice":149.97,"latestSource":"Close","latestUpdate":1573506000404,"iexBidPrice":null,"iexBidSize":null,"iexAskPrice":null,"iexAskSize":null},"bids":[],"asks":[],"trades":[],"systemEvent":{"systemEvent":"C","timestamp":1573520400000}}`,
+		"/stock/msft/chart/1d":     `[{"date":"2019-11-11","minute":"15:58","label":"3:58 PM","high":149.98,"low":149.9,"average":149.94,"volume":4200,"notional":629748,"numberOfTrades":35,"marketHigh":150,"marketLow":149.88,"marketAverage":149.95,"marketVolume":210500,"marketNotional":31564475,"marketNumberOfTrades":1320,"open":149.92,"close":149.96,"marketOpen":149.91,"marketClose":149.97,"changeOverTime":0.0169,"marketChangeOverTime":0.0168},{"date":"2019-11-11","minute":"15:59","label":"3:59 PM","high":150.02,"low":149.93,"average":149.98,"volume":8100,"notional":1214838,"numberOfTrades":61,"marketHigh":150.05,"marketLow":149.92,"marketAverage":149.99,"marketVolume":533400,"marketNotional":80004666,"marketNumberOfTrades":2874,"open":149.96,"close":149.97,"marketOpen":149.97,"marketClose":149.97,"changeOverTime":0.0170,"marketChangeOverTime":0.0170}]`,
+		"/stock/msft/stats":        `{"companyName":"Microsoft Corp.","marketCap":1145310000000,"week52High":150.3,"week52Low":93.96,"week52Change":0.395,"sharesOutstanding":7636920000,"avg30Volume":22784000,"avg10Volume":20455000,"float":7537000000,"employees":144000,"ttmEPS":5.06,"ttmDividendRate":1.94,"dividendYield":0.0129,"nextDividendDate":"2019-12-12","exDividendDate":"2019-11-20","nextEarningsDate":"2020-01-29","peRatio":29.64,"beta":1.23,"day200MovingAvg":133.27,"day50MovingAvg":141.52,"maxChangePercent":1573.5,"year5ChangePercent":2.1874,"year2ChangePercent":0.7708,"year1ChangePercent":0.3951,"ytdChangePercent":0.4765,"month6ChangePercent":0.1733,"month3ChangePercent":0.0803,"month1ChangePercent":0.0617,"day30ChangePercent":0.0617,"day5ChangePercent":0.0151}`,
+		"/stock/msft/dividends/1y": `[{"exDate":"2019-11-20","paymentDate":"2019-12-12","recordDate":"2019-11-21","declaredDate":"2019-09-18","amount":"0.51","flag":"Cash"}]`,
+		"/stock/msft/dividends/5y": `[{"exDate":"2019-11-20","paymentDate":"2019-12-12","recordDate":"2019-11-21","declaredDate":"2019-09-18","amount":"0.51","flag":"Cash"},{"exDate":"2015-02-17","paymentDate":"2015-03-12","recordDate":"2015-02-19","declaredDate":"2014-11-25","amount":"0.31","flag":"Cash"}]`,
+	}
+}
@@ -0,0 +1,238 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package iextest provides a fake IEX Cloud API serving fixtures, to run the
+// collectors and the exporter without a token nor network access.
+package iextest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vglafirov/iexcloud_exporter/pkg/transport"
+)
+
+// MaxBatchSymbols is the maximum number of symbols of a batch request
+const MaxBatchSymbols = 100
+
+// Fault replaces the responses of the matching requests with an error, a
+// delay, or both
+type Fault struct {
+	// Path is the prefix of the affected request paths, all the requests
+	// are affected if empty
+	Path string
+	// Status is the status code sent instead of the fixture, 0 sends the
+	// fixture
+	Status int
+	// Delay is the time waited before responding
+	Delay time.Duration
+	// Times is the number of affected requests, 0 affects all of them
+	Times int
+}
+
+// ParseFault parses a fault from "[<path>=]<status|delay>[x<times>]", e.g.
+// "/stock/msft=500", "/status=2s" or "429x3"
+func ParseFault(s string) (Fault, error) {
+	var f Fault
+	value := s
+	if i := strings.LastIndexByte(s, '='); i >= 0 {
+		f.Path, value = s[:i], s[i+1:]
+	}
+	if i := strings.LastIndexByte(value, 'x'); i >= 0 {
+		times, err := strconv.Atoi(value[i+1:])
+		if err != nil || times <= 0 {
+			return f, fmt.Errorf("invalid fault %q: invalid number of requests %q", s, value[i+1:])
+		}
+		f.Times, value = times, value[:i]
+	}
+	if status, err := strconv.Atoi(value); err == nil {
+		if status < 100 || status > 599 {
+			return f, fmt.Errorf("invalid fault %q: invalid status code %d", s, status)
+		}
+		f.Status = status
+		return f, nil
+	}
+	delay, err := time.ParseDuration(value)
+	if err != nil || delay <= 0 {
+		return f, fmt.Errorf("invalid fault %q: expected a status code or a delay", s)
+	}
+	f.Delay = delay
+	return f, nil
+}
+
+// Server is a fake IEX Cloud API. It serves the fixtures of the price, stats,
+// dividends, batch, account and status endpoints under any API version.
+type Server struct {
+	fixtures transport.Fixtures
+
+	mtx      sync.Mutex
+	faults   []*fault
+	requests []string
+}
+
+// fault is an injected fault and its number of requests left to affect
+type fault struct {
+	Fault
+	left int
+}
+
+// NewServer returns a server of the fixtures
+func NewServer(fixtures transport.Fixtures) *Server {
+	return &Server{fixtures: fixtures}
+}
+
+// Inject adds a fault, faults are matched in the order they were added
+func (s *Server) Inject(f Fault) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.faults = append(s.faults, &fault{Fault: f, left: f.Times})
+}
+
+// Reset removes the faults and forgets the served requests
+func (s *Server) Reset() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.faults, s.requests = nil, nil
+}
+
+// Requests returns the paths of the served requests, in order
+func (s *Server) Requests() []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// fault returns the first fault matching the path, if any, and counts the
+// affected request
+func (s *Server) fault(p string) *Fault {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.requests = append(s.requests, p)
+	for _, f := range s.faults {
+		if !strings.HasPrefix(p, f.Path) || (f.Times > 0 && f.left == 0) {
+			continue
+		}
+		if f.Times > 0 {
+			f.left--
+		}
+		return &f.Fault
+	}
+	return nil
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := transport.Path(r.URL.Path)
+	if f := s.fault(p); f != nil {
+		if f.Delay > 0 {
+			select {
+			case <-time.After(f.Delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if f.Status != 0 {
+			writeError(w, f.Status)
+			return
+		}
+	}
+
+	if p != "/status" && r.URL.Query().Get("token") == "" {
+		http.Error(w, "An API key is required to access the requested endpoint", http.StatusUnauthorized)
+		return
+	}
+
+	// Recorded responses are served as is, including batches
+	body, ok := s.fixtures.Get(transport.Key(r.URL))
+	if !ok && p == "/stock/market/batch" {
+		s.serveBatch(w, r)
+		return
+	}
+	if !ok {
+		body, ok = s.fixtures.Get(p)
+	}
+	if !ok {
+		http.Error(w, "Unknown symbol", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write([]byte(body))
+}
+
+// serveBatch assembles the batch response from the fixtures of the symbols,
+// the unknown symbols and types are left out like IEX Cloud does
+func (s *Server) serveBatch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	symbols := strings.Split(q.Get("symbols"), ",")
+	if q.Get("symbols") == "" || q.Get("types") == "" {
+		http.Error(w, "Symbols and types are required", http.StatusBadRequest)
+		return
+	}
+	if len(symbols) > MaxBatchSymbols {
+		http.Error(w, fmt.Sprintf("Batch requests are limited to %d symbols", MaxBatchSymbols), http.StatusBadRequest)
+		return
+	}
+	pathRange := q.Get("range")
+	if pathRange == "" {
+		pathRange = "1m"
+	}
+
+	response := make(map[string]map[string]json.RawMessage)
+	for _, symbol := range symbols {
+		data := make(map[string]json.RawMessage)
+		for _, t := range strings.Split(q.Get("types"), ",") {
+			p := "/stock/" + symbol + "/" + t
+			if t == "dividends" {
+				p += "/" + pathRange
+			}
+			if body, ok := s.fixtures.Get(p); ok {
+				data[t] = json.RawMessage(body)
+			}
+		}
+		if len(data) > 0 {
+			response[strings.ToUpper(symbol)] = data
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(response)
+}
+
+// writeError sends the injected status code with the message IEX Cloud sends
+// for it
+func writeError(w http.ResponseWriter, status int) {
+	message := http.StatusText(status)
+	switch status {
+	case http.StatusPaymentRequired:
+		message = "You have exceeded your allotted message quota and pay-as-you-go is not enabled."
+	case http.StatusTooManyRequests:
+		w.Header().Set("Retry-After", "1")
+		message = "Too many requests"
+	}
+	http.Error(w, message, status)
+}
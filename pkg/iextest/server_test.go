@@ -0,0 +1,144 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package iextest
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, strings.TrimSpace(string(body))
+}
+
+func TestServer(t *testing.T) {
+	server := httptest.NewServer(NewServer(DefaultFixtures()))
+	defer server.Close()
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{path: "/stable//stock/AAPL/price?token=test", status: 200, body: "261.78"},
+		{path: "/stock/aapl/price?token=test", status: 200, body: "261.78"},
+		{path: "/stable/stock/aapl/price", status: 401},
+		{path: "/stable/status", status: 200, body: `{"status":"up","version":"beta","time":1573430400000}`},
+		{path: "/stable/stock/goog/price?token=test", status: 404},
+		{path: "/stable/stock/market/batch?token=test&symbols=aapl,goog&types=price,news", status: 200, body: `{"AAPL":{"price":261.78}}`},
+		{path: "/stable/stock/market/batch?token=test&symbols=msft&types=dividends&range=1y", status: 200, body: `{"MSFT":{"dividends":[{"exDate":"2019-11-20","paymentDate":"2019-12-12","recordDate":"2019-11-21","declaredDate":"2019-09-18","amount":"0.51","flag":"Cash"}]}}`},
+		{path: "/stable/stock/market/batch?token=test&types=price", status: 400},
+	}
+	for _, test := range tests {
+		status, body := get(t, server.URL+test.path)
+		if status != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.path, test.status, status)
+			continue
+		}
+		if test.body != "" && body != test.body {
+			t.Errorf("%s: expected %s, got %s", test.path, test.body, body)
+		}
+	}
+}
+
+func TestServerFaults(t *testing.T) {
+	s := NewServer(DefaultFixtures())
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	s.Inject(Fault{Path: "/stock/msft", Status: http.StatusInternalServerError})
+	s.Inject(Fault{Status: http.StatusTooManyRequests, Times: 1})
+	if status, _ := get(t, server.URL+"/stable/stock/msft/price?token=test"); status != 500 {
+		t.Errorf("expected the msft fault, got status %d", status)
+	}
+	if status, _ := get(t, server.URL+"/stable/stock/aapl/price?token=test"); status != 429 {
+		t.Errorf("expected the fault of all the requests, got status %d", status)
+	}
+	if status, _ := get(t, server.URL+"/stable/stock/aapl/price?token=test"); status != 200 {
+		t.Errorf("expected the fault to affect one request, got status %d", status)
+	}
+	if status, _ := get(t, server.URL+"/stable/stock/msft/price?token=test"); status != 500 {
+		t.Errorf("expected the msft fault to affect all the requests, got status %d", status)
+	}
+	if n := len(s.Requests()); n != 4 {
+		t.Errorf("expected 4 requests, got %d", n)
+	}
+
+	s.Reset()
+	s.Inject(Fault{Path: "/status", Delay: time.Minute})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/stable/status", nil)
+	if _, err := http.DefaultClient.Do(req.WithContext(ctx)); err == nil {
+		t.Error("expected the slow response to time out")
+	}
+}
+
+func TestParseFault(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Fault
+		err  bool
+	}{
+		{in: "/stock/msft=500", want: Fault{Path: "/stock/msft", Status: 500}},
+		{in: "/status=2s", want: Fault{Path: "/status", Delay: 2 * time.Second}},
+		{in: "429x3", want: Fault{Status: 429, Times: 3}},
+		{in: "/account=402x1", want: Fault{Path: "/account", Status: 402, Times: 1}},
+		{in: "/status=600", err: true},
+		{in: "/status=often", err: true},
+		{in: "500x0", err: true},
+	}
+	for _, test := range tests {
+		f, err := ParseFault(test.in)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.in, err)
+			continue
+		}
+		if f != test.want {
+			t.Errorf("%s: expected %+v, got %+v", test.in, test.want, f)
+		}
+	}
+}
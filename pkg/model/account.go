@@ -0,0 +1,194 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	// TODO: Replace with github.com/goinvest/iexcloud once https://github.com/goinvest/iexcloud/issues/41 is closed
+	iex "github.com/vglafirov/iexcloud"
+	"github.com/vglafirov/iexcloud_exporter/pkg/config"
+)
+
+var (
+	// AccountInfo Prometheus metric definition for the account plan
+	AccountInfo = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "account", "info"),
+		"Plan of the IEX Cloud account.",
+		[]string{"tier", "pay_as_you_go", "subscription_term"},
+		nil,
+	)
+
+	// AccountMessagesUsed Prometheus metric definition for the messages used
+	AccountMessagesUsed = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "account", "messages_used"),
+		"Number of messages used in the current billing period.",
+		nil,
+		nil,
+	)
+
+	// AccountMessageLimit Prometheus metric definition for the message limit
+	AccountMessageLimit = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "account", "message_limit"),
+		"Number of messages included in the plan for the billing period.",
+		nil,
+		nil,
+	)
+
+	// AccountMonthlyUsage Prometheus metric definition for the monthly usage
+	AccountMonthlyUsage = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "account", "monthly_usage_messages"),
+		"Number of messages used in the current month.",
+		nil,
+		nil,
+	)
+
+	// AccountMonthlyPayAsYouGo Prometheus metric definition for the monthly pay-as-you-go usage
+	AccountMonthlyPayAsYouGo = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "account", "monthly_pay_as_you_go_messages"),
+		"Number of pay-as-you-go messages used in the current month.",
+		nil,
+		nil,
+	)
+
+	// AccountDailyUsage Prometheus metric definition for the usage per day
+	AccountDailyUsage = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "account", "daily_usage_messages"),
+		"Number of messages used per day of the current month.",
+		[]string{"date"},
+		nil,
+	)
+
+	// AccountTokenUsage Prometheus metric definition for the usage per token
+	AccountTokenUsage = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "account", "token_usage_messages"),
+		"Number of messages used per token in the current month.",
+		[]string{"token"},
+		nil,
+	)
+
+	// AccountKeyUsage Prometheus metric definition for the usage per key
+	AccountKeyUsage = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "account", "key_usage_messages"),
+		"Number of messages used per key in the current month.",
+		[]string{"key"},
+		nil,
+	)
+)
+
+// Account data
+type Account struct{}
+
+func init() {
+	Register("account", func() Collector { return &Account{} })
+}
+
+// Name returns the config key of the account group
+func (a *Account) Name() string {
+	return "account"
+}
+
+// Configure decodes and validates the account group parameters. The group
+// takes none.
+func (a *Account) Configure(params json.RawMessage) error {
+	return config.Decode(params, a)
+}
+
+// Describe sends the account metric descriptors
+func (a *Account) Describe(ch chan<- *prometheus.Desc) {
+	ch <- AccountInfo
+	ch <- AccountMessagesUsed
+	ch <- AccountMessageLimit
+	ch <- AccountMonthlyUsage
+	ch <- AccountMonthlyPayAsYouGo
+	ch <- AccountDailyUsage
+	ch <- AccountTokenUsage
+	ch <- AccountKeyUsage
+}
+
+// Collect Account metadata and usage API calls. Both require a secret token.
+func (a *Account) Collect(ctx context.Context, client *iex.Client, ch chan<- prometheus.Metric) error {
+	metadata, metadataErr := client.AccountMetadata()
+	if metadataErr == nil {
+		ch <- prometheus.MustNewConstMetric(
+			AccountInfo, prometheus.GaugeValue, 1,
+			metadata.TierName, strconv.FormatBool(metadata.PayAsYouGo), metadata.SubscriptionTerm,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			AccountMessagesUsed, prometheus.GaugeValue, float64(metadata.MessagesUsed),
+		)
+		ch <- prometheus.MustNewConstMetric(
+			AccountMessageLimit, prometheus.GaugeValue, float64(metadata.MessageLimit),
+		)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	usage, err := client.Usage()
+	if err != nil {
+		return err
+	}
+	ch <- prometheus.MustNewConstMetric(
+		AccountMonthlyUsage, prometheus.GaugeValue, float64(usage.MonthlyUsage),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		AccountMonthlyPayAsYouGo, prometheus.GaugeValue, float64(usage.MonthlyPayAsYouGo),
+	)
+	for date, messages := range usage.DailyUsage {
+		ch <- prometheus.MustNewConstMetric(
+			AccountDailyUsage, prometheus.GaugeValue, float64(messages), date,
+		)
+	}
+	for token, messages := range usage.TokenUsage {
+		ch <- prometheus.MustNewConstMetric(
+			AccountTokenUsage, prometheus.GaugeValue, float64(messages), redact(token),
+		)
+	}
+	for key, messages := range usage.KeyUsage {
+		ch <- prometheus.MustNewConstMetric(
+			AccountKeyUsage, prometheus.GaugeValue, float64(messages), key,
+		)
+	}
+	return metadataErr
+}
+
+// redact hides all but the prefix and the last characters of a token, enough
+// to tell the tokens of the account apart
+func redact(token string) string {
+	const visible = 4
+	if len(token) <= 2*visible {
+		return token
+	}
+	prefix := ""
+	if i := strings.IndexByte(token, '_'); i >= 0 && i < visible {
+		prefix = token[:i+1]
+	}
+	return prefix + "..." + token[len(token)-visible:]
+}
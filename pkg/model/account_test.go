@@ -0,0 +1,91 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	iex "github.com/vglafirov/iexcloud"
+	"github.com/vglafirov/iexcloud_exporter/pkg/iextest"
+)
+
+func TestAccount(t *testing.T) {
+	s := iextest.NewServer(iextest.DefaultFixtures())
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	a := &Account{}
+	if err := a.Configure([]byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Configure([]byte(`{"symbols":["aapl"]}`)); err == nil {
+		t.Error("expected an error for unknown parameters")
+	}
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(collectorFunc{a, iex.NewClient("sk_test", server.URL+"/stable/")})
+	expected := `
+# HELP iexcloud_account_daily_usage_messages Number of messages used per day of the current month.
+# TYPE iexcloud_account_daily_usage_messages gauge
+iexcloud_account_daily_usage_messages{date="20191109"} 100000
+iexcloud_account_daily_usage_messages{date="20191110"} 23456
+# HELP iexcloud_account_info Plan of the IEX Cloud account.
+# TYPE iexcloud_account_info gauge
+iexcloud_account_info{pay_as_you_go="false",subscription_term="monthly",tier="launch"} 1
+# HELP iexcloud_account_key_usage_messages Number of messages used per key in the current month.
+# TYPE iexcloud_account_key_usage_messages gauge
+iexcloud_account_key_usage_messages{key="price"} 2456
+iexcloud_account_key_usage_messages{key="stats"} 121000
+# HELP iexcloud_account_message_limit Number of messages included in the plan for the billing period.
+# TYPE iexcloud_account_message_limit gauge
+iexcloud_account_message_limit 5e+06
+# HELP iexcloud_account_messages_used Number of messages used in the current billing period.
+# TYPE iexcloud_account_messages_used gauge
+iexcloud_account_messages_used 123456
+# HELP iexcloud_account_monthly_pay_as_you_go_messages Number of pay-as-you-go messages used in the current month.
+# TYPE iexcloud_account_monthly_pay_as_you_go_messages gauge
+iexcloud_account_monthly_pay_as_you_go_messages 0
+# HELP iexcloud_account_monthly_usage_messages Number of messages used in the current month.
+# TYPE iexcloud_account_monthly_usage_messages gauge
+iexcloud_account_monthly_usage_messages 123456
+# HELP iexcloud_account_token_usage_messages Number of messages used per token in the current month.
+# TYPE iexcloud_account_token_usage_messages gauge
+iexcloud_account_token_usage_messages{token="pk_...cdef"} 123456
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	// Without a secret token IEX Cloud answers 402
+	s.Inject(iextest.Fault{Path: "/account", Status: http.StatusPaymentRequired})
+	if _, _, err := collect(a, iex.NewClient("pk_test", server.URL+"/stable/")); err == nil {
+		t.Error("expected an error")
+	}
+}
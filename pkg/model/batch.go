@@ -0,0 +1,286 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	// TODO: Replace with github.com/goinvest/iexcloud once https://github.com/goinvest/iexcloud/issues/41 is closed
+	iex "github.com/vglafirov/iexcloud"
+)
+
+// BatchMaxSymbols maximum number of symbols IEX Cloud accepts in one batch request
+const BatchMaxSymbols = 100
+
+// BatchEndpoint path of the batch endpoint
+const BatchEndpoint = "stock/market/batch"
+
+// Data types of the batch endpoint
+const (
+	BatchPrice     = "price"
+	BatchStats     = "stats"
+	BatchDividends = "dividends"
+	BatchQuote     = "quote"
+	BatchOHLC      = "ohlc"
+	BatchPrevious  = "previous"
+	BatchBook      = "book"
+)
+
+// BatchRequest a data type requested from the batch endpoint for one symbol
+type BatchRequest struct {
+	Symbol string
+	Type   string
+	// Range of the dividends, ignored for the other types
+	Range iex.PathRange
+}
+
+// BatchData batch endpoint response for one symbol
+type BatchData struct {
+	Price *float64
+	Stats *iex.KeyStats
+	Quote *QuoteData
+	OHLC  *OHLCData
+	// Previous trading day
+	Previous *iex.PreviousDay
+	// Bids and asks of the IEX book
+	Book *iex.DEEPBook
+	// Dividends by range
+	Dividends map[iex.PathRange][]iex.Dividend
+	// Err error of a failed batch request of the symbol
+	Err error
+}
+
+// BatchResult batch endpoint responses by symbol
+type BatchResult map[string]*BatchData
+
+// Get returns the data fetched for the symbol, or nil
+func (r BatchResult) Get(symbol string) *BatchData {
+	return r[strings.ToUpper(symbol)]
+}
+
+// Missing returns the error of a symbol whose data is missing from the
+// result. The error of its failed batch request is wrapped, so that the
+// failures caused by an exhausted budget or an open circuit are told apart.
+func (r BatchResult) Missing(symbol, data string) error {
+	if d := r.Get(symbol); d != nil && d.Err != nil {
+		return fmt.Errorf("no %s returned for %s: %w", data, symbol, d.Err)
+	}
+	return fmt.Errorf("no %s returned for %s", data, symbol)
+}
+
+func (r BatchResult) data(symbol string) *BatchData {
+	data, ok := r[symbol]
+	if !ok {
+		data = &BatchData{Dividends: make(map[iex.PathRange][]iex.Dividend)}
+		r[symbol] = data
+	}
+	return data
+}
+
+// BatchCollector is implemented by the collectors which can be served from the
+// batch endpoint. Requests of all the groups refreshed together are merged.
+type BatchCollector interface {
+	Collector
+	// BatchRequests returns the data the collector needs
+	BatchRequests() []BatchRequest
+	// CollectBatch sends the metrics built from the fetched data
+	CollectBatch(ctx context.Context, result BatchResult, ch chan<- prometheus.Metric) error
+}
+
+// batchCall is one batch endpoint request, made for up to BatchMaxSymbols
+// symbols at a time
+type batchCall struct {
+	types     []string
+	dividends bool
+	pathRange iex.PathRange
+}
+
+func (c batchCall) key() string {
+	if !c.dividends {
+		return strings.Join(c.types, ",")
+	}
+	return strings.Join(c.types, ",") + "&range=" + iex.PathRangeJSON[c.pathRange]
+}
+
+// FetchBatch fetches the requested data using as few batch endpoint requests
+// as possible. Every symbol is requested once per data type, symbols which
+// need the same data types are requested together. An error is returned if
+// any of the requests fails, along with the data which could be fetched, and
+// recorded for the symbols of the request. Once ctx is done the remaining
+// requests are skipped.
+func FetchBatch(ctx context.Context, client *iex.Client, requests []BatchRequest) (BatchResult, error) {
+	types := make(map[string]map[string]bool)
+	ranges := make(map[string]map[iex.PathRange]bool)
+	for _, r := range requests {
+		symbol := strings.ToUpper(r.Symbol)
+		if r.Type == BatchDividends {
+			if ranges[symbol] == nil {
+				ranges[symbol] = make(map[iex.PathRange]bool)
+			}
+			ranges[symbol][r.Range] = true
+			continue
+		}
+		if types[symbol] == nil {
+			types[symbol] = make(map[string]bool)
+		}
+		types[symbol][r.Type] = true
+	}
+
+	calls := make(map[string]batchCall)
+	symbols := make(map[string][]string)
+	add := func(symbol string, call batchCall) {
+		key := call.key()
+		calls[key] = call
+		symbols[key] = append(symbols[key], symbol)
+	}
+	for _, symbol := range batchSymbols(types, ranges) {
+		var call batchCall
+		for t := range types[symbol] {
+			call.types = append(call.types, t)
+		}
+		sort.Strings(call.types)
+
+		var pathRanges []iex.PathRange
+		for r := range ranges[symbol] {
+			pathRanges = append(pathRanges, r)
+		}
+		sort.Slice(pathRanges, func(i, j int) bool { return pathRanges[i] < pathRanges[j] })
+
+		// The range parameter applies to the whole request, so only the first
+		// range of dividends is fetched along with the other types
+		if len(pathRanges) > 0 {
+			call.types = append(call.types, BatchDividends)
+			call.dividends = true
+			call.pathRange = pathRanges[0]
+			pathRanges = pathRanges[1:]
+		}
+		add(symbol, call)
+		for _, r := range pathRanges {
+			add(symbol, batchCall{types: []string{BatchDividends}, dividends: true, pathRange: r})
+		}
+	}
+
+	keys := make([]string, 0, len(calls))
+	for key := range calls {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := make(BatchResult)
+	var firstErr error
+	for _, key := range keys {
+		call := calls[key]
+		for chunk := symbols[key]; len(chunk) > 0; {
+			n := len(chunk)
+			if n > BatchMaxSymbols {
+				n = BatchMaxSymbols
+			}
+			err := ctx.Err()
+			if err == nil {
+				err = call.fetch(client, chunk[:n], result)
+			}
+			if err != nil {
+				for _, symbol := range chunk[:n] {
+					if data := result.data(symbol); data.Err == nil {
+						data.Err = err
+					}
+				}
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+			chunk = chunk[n:]
+		}
+	}
+	return result, firstErr
+}
+
+func batchSymbols(types map[string]map[string]bool, ranges map[string]map[iex.PathRange]bool) []string {
+	seen := make(map[string]bool)
+	var symbols []string
+	for symbol := range types {
+		seen[symbol] = true
+		symbols = append(symbols, symbol)
+	}
+	for symbol := range ranges {
+		if !seen[symbol] {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+func (c batchCall) fetch(client *iex.Client, symbols []string, result BatchResult) error {
+	endpoint := fmt.Sprintf("/%s?symbols=%s&types=%s", BatchEndpoint,
+		url.QueryEscape(strings.Join(symbols, ",")), url.QueryEscape(strings.Join(c.types, ",")))
+	if c.dividends {
+		endpoint += "&range=" + iex.PathRangeJSON[c.pathRange]
+	}
+
+	var response map[string]struct {
+		Price     *float64         `json:"price"`
+		Stats     *iex.KeyStats    `json:"stats"`
+		Dividends []iex.Dividend   `json:"dividends"`
+		Quote     *QuoteData       `json:"quote"`
+		OHLC      *OHLCData        `json:"ohlc"`
+		Previous  *iex.PreviousDay `json:"previous"`
+		Book      *iex.DEEPBook    `json:"book"`
+	}
+	if err := client.GetJSON(endpoint, &response); err != nil {
+		return fmt.Errorf("batch request for %d symbols failed: %w", len(symbols), err)
+	}
+
+	for symbol, r := range response {
+		data := result.data(strings.ToUpper(symbol))
+		if r.Price != nil {
+			data.Price = r.Price
+		}
+		if r.Stats != nil {
+			data.Stats = r.Stats
+		}
+		if r.Quote != nil {
+			data.Quote = r.Quote
+		}
+		if r.OHLC != nil {
+			data.OHLC = r.OHLC
+		}
+		if r.Previous != nil {
+			data.Previous = r.Previous
+		}
+		if r.Book != nil {
+			data.Book = r.Book
+		}
+		if c.dividends && r.Dividends != nil {
+			data.Dividends[c.pathRange] = r.Dividends
+		}
+	}
+	return nil
+}
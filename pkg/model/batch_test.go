@@ -0,0 +1,134 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	iex "github.com/vglafirov/iexcloud"
+	"github.com/vglafirov/iexcloud_exporter/pkg/transport"
+)
+
+func TestFetchBatch(t *testing.T) {
+	var (
+		mtx   sync.Mutex
+		calls []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		symbols := strings.Split(q.Get("symbols"), ",")
+		mtx.Lock()
+		calls = append(calls, fmt.Sprintf("%d:%s:%s", len(symbols), q.Get("types"), q.Get("range")))
+		mtx.Unlock()
+
+		response := make(map[string]map[string]interface{})
+		for _, symbol := range symbols {
+			data := make(map[string]interface{})
+			for _, t := range strings.Split(q.Get("types"), ",") {
+				switch t {
+				case BatchPrice:
+					data[t] = 1.5
+				case BatchStats:
+					data[t] = map[string]interface{}{"marketCap": 100}
+				case BatchDividends:
+					data[t] = []map[string]interface{}{{"amount": "0.77"}}
+				}
+			}
+			response[symbol] = data
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	var requests []BatchRequest
+	for i := 0; i < 150; i++ {
+		symbol := fmt.Sprintf("s%d", i)
+		requests = append(requests,
+			BatchRequest{Symbol: symbol, Type: BatchPrice},
+			// The same symbol requested by two groups
+			BatchRequest{Symbol: symbol, Type: BatchPrice},
+		)
+	}
+	requests = append(requests,
+		BatchRequest{Symbol: "aapl", Type: BatchStats},
+		BatchRequest{Symbol: "aapl", Type: BatchDividends, Range: iex.Yr1},
+		BatchRequest{Symbol: "aapl", Type: BatchDividends, Range: iex.Yr5},
+	)
+
+	result, err := FetchBatch(context.Background(), iex.NewClient("token", server.URL), requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(calls)
+	want := []string{"100:price:", "1:dividends:5y", "1:stats,dividends:1y", "50:price:"}
+	if strings.Join(calls, " ") != strings.Join(want, " ") {
+		t.Fatalf("expected calls %v, got %v", want, calls)
+	}
+
+	if data := result.Get("s149"); data == nil || data.Price == nil || *data.Price != 1.5 {
+		t.Errorf("missing price for s149")
+	}
+	data := result.Get("aapl")
+	if data == nil || data.Stats == nil || data.Stats.MarketCap != 100 {
+		t.Fatalf("missing key stats for aapl")
+	}
+	if len(data.Dividends[iex.Yr1]) != 1 || len(data.Dividends[iex.Yr5]) != 1 {
+		t.Errorf("missing dividends for aapl: %v", data.Dividends)
+	}
+}
+
+type failingTransport struct{ err error }
+
+func (t failingTransport) RoundTrip(*http.Request) (*http.Response, error) { return nil, t.err }
+
+func TestFetchBatchFailure(t *testing.T) {
+	client := iex.NewClient("token", "https://cloud.iexapis.com/stable",
+		iex.WithHTTPClient(&http.Client{Transport: failingTransport{transport.ErrBudgetExhausted}}))
+	result, err := FetchBatch(context.Background(), client, []BatchRequest{{Symbol: "aapl", Type: BatchPrice}})
+	if !errors.Is(err, transport.ErrBudgetExhausted) {
+		t.Fatalf("expected the budget error, got %v", err)
+	}
+
+	price := &Price{Symbols: []string{"aapl"}}
+	report := NewReport()
+	if err := price.CollectBatch(WithReport(context.Background(), report), result, make(chan prometheus.Metric)); err != nil {
+		t.Fatal(err)
+	}
+	failures := report.Failures()
+	if len(failures) != 1 || !errors.Is(failures[0].Err, transport.ErrBudgetExhausted) {
+		t.Errorf("expected the symbol failure to wrap the budget error, got %v", failures)
+	}
+}
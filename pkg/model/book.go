@@ -0,0 +1,247 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	// TODO: Replace with github.com/goinvest/iexcloud once https://github.com/goinvest/iexcloud/issues/41 is closed
+	iex "github.com/vglafirov/iexcloud"
+	"github.com/vglafirov/iexcloud_exporter/pkg/config"
+)
+
+// Defaults of the book group parameters
+var (
+	DefaultBookLevels   = 5
+	DefaultBookDepthBps = []float64{10, 50}
+)
+
+func newBookDesc(name, help string, labels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "book", name),
+		help,
+		append([]string{"symbol"}, labels...),
+		nil,
+	)
+}
+
+// Prometheus metric definitions of the IEX order book
+var (
+	BookBidPrice   = newBookDesc("bid_price", "Best bid price on IEX.")
+	BookBidSize    = newBookDesc("bid_size", "Number of shares at the best bid price on IEX.")
+	BookAskPrice   = newBookDesc("ask_price", "Best ask price on IEX.")
+	BookAskSize    = newBookDesc("ask_size", "Number of shares at the best ask price on IEX.")
+	BookSpread     = newBookDesc("spread", "Best ask price minus best bid price.")
+	BookMidPrice   = newBookDesc("mid_price", "Mean of the best bid and ask prices.")
+	BookLevelPrice = newBookDesc("level_price", "Price of the price level of the book, 1 is the best.", "side", "level")
+	BookLevelSize  = newBookDesc("level_size", "Number of shares at the price level of the book, 1 is the best.", "side", "level")
+	BookDepth      = newBookDesc("depth_size", "Number of shares of the price levels within bps basis points of the mid price.", "side", "bps")
+)
+
+// Book IEX order book of the symbols: the best bid and ask, the sizes of the
+// top price levels and the depth around the mid price. The book is fetched
+// from the stock book endpoint, merged into the batch requests of the other
+// groups.
+type Book struct {
+	Symbols  []string
+	Levels   int
+	DepthBps []float64
+}
+
+func init() {
+	Register("book", func() Collector { return &Book{} })
+}
+
+// Name returns the config key of the book group
+func (b *Book) Name() string {
+	return "book"
+}
+
+// RealTime marks the book group as real-time
+func (b *Book) RealTime() {}
+
+// Configure decodes and validates the book group parameters
+func (b *Book) Configure(params json.RawMessage) error {
+	var p struct {
+		Symbols  []string  `json:"symbols"`
+		Levels   *int      `json:"levels"`
+		DepthBps []float64 `json:"depth_bps"`
+	}
+	if err := config.Decode(params, &p); err != nil {
+		return err
+	}
+	if err := config.ValidateSymbols(p.Symbols); err != nil {
+		return err
+	}
+
+	b.Symbols, b.Levels, b.DepthBps = p.Symbols, DefaultBookLevels, DefaultBookDepthBps
+	if p.Levels != nil {
+		if *p.Levels < 0 {
+			return config.WrapError("levels", errors.New("must not be negative"))
+		}
+		b.Levels = *p.Levels
+	}
+	if p.DepthBps != nil {
+		seen := make(map[float64]bool, len(p.DepthBps))
+		for i, bps := range p.DepthBps {
+			if bps <= 0 {
+				return config.WrapError(fmt.Sprintf("depth_bps[%d]", i), fmt.Errorf("must be positive, got %g", bps))
+			}
+			// The depths of the same distance would be the same series
+			if seen[bps] {
+				return config.WrapError(fmt.Sprintf("depth_bps[%d]", i), fmt.Errorf("duplicate value %g", bps))
+			}
+			seen[bps] = true
+		}
+		b.DepthBps = p.DepthBps
+	}
+	return nil
+}
+
+// Describe sends the book metric descriptors
+func (b *Book) Describe(ch chan<- *prometheus.Desc) {
+	ch <- BookBidPrice
+	ch <- BookBidSize
+	ch <- BookAskPrice
+	ch <- BookAskSize
+	ch <- BookSpread
+	ch <- BookMidPrice
+	ch <- BookLevelPrice
+	ch <- BookLevelSize
+	ch <- BookDepth
+}
+
+// Collect Book API call. Symbols which cannot be collected are recorded in
+// the report and skipped.
+func (b *Book) Collect(ctx context.Context, client *iex.Client, ch chan<- prometheus.Metric) error {
+	report := ReportFrom(ctx)
+	for _, symbol := range b.Symbols {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		report.Attempt(symbol)
+		book, err := client.Book(symbol)
+		if err != nil {
+			report.Fail(symbol, "stock/"+symbol+"/book", err)
+			continue
+		}
+		b.collect(symbol, book.Bids, book.Asks, ch)
+	}
+	return nil
+}
+
+// collect sends the metrics of the book. The best prices are only sent for
+// the sides which have orders, the spread, mid price and depth when both
+// sides have.
+func (b *Book) collect(symbol string, bids, asks []iex.BidAsk, ch chan<- prometheus.Metric) {
+	bids = sortLevels(bids, func(x, y float64) bool { return x > y })
+	asks = sortLevels(asks, func(x, y float64) bool { return x < y })
+
+	send := func(desc *prometheus.Desc, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, append([]string{symbol}, labels...)...)
+	}
+	if len(bids) > 0 {
+		send(BookBidPrice, bids[0].Price)
+		send(BookBidSize, float64(bids[0].Size))
+	}
+	if len(asks) > 0 {
+		send(BookAskPrice, asks[0].Price)
+		send(BookAskSize, float64(asks[0].Size))
+	}
+	for _, side := range []struct {
+		name   string
+		levels []iex.BidAsk
+	}{{"bid", bids}, {"ask", asks}} {
+		for i := 0; i < b.Levels && i < len(side.levels); i++ {
+			level := strconv.Itoa(i + 1)
+			send(BookLevelPrice, side.levels[i].Price, side.name, level)
+			send(BookLevelSize, float64(side.levels[i].Size), side.name, level)
+		}
+	}
+	if len(bids) == 0 || len(asks) == 0 {
+		return
+	}
+
+	mid := (bids[0].Price + asks[0].Price) / 2
+	send(BookSpread, asks[0].Price-bids[0].Price)
+	send(BookMidPrice, mid)
+	for _, bps := range b.DepthBps {
+		label := strconv.FormatFloat(bps, 'g', -1, 64)
+		within := mid * bps / 10000
+		send(BookDepth, depth(bids, func(p float64) bool { return p >= mid-within }), "bid", label)
+		send(BookDepth, depth(asks, func(p float64) bool { return p <= mid+within }), "ask", label)
+	}
+}
+
+// sortLevels returns a copy of the price levels, the best first
+func sortLevels(levels []iex.BidAsk, better func(x, y float64) bool) []iex.BidAsk {
+	sorted := append([]iex.BidAsk(nil), levels...)
+	sort.SliceStable(sorted, func(i, j int) bool { return better(sorted[i].Price, sorted[j].Price) })
+	return sorted
+}
+
+// depth returns the number of shares of the sorted price levels until the
+// first one whose price is not in range
+func depth(levels []iex.BidAsk, inRange func(price float64) bool) float64 {
+	var size float64
+	for _, l := range levels {
+		if !inRange(l.Price) {
+			break
+		}
+		size += float64(l.Size)
+	}
+	return size
+}
+
+// BatchRequests returns the books to fetch from the batch endpoint
+func (b *Book) BatchRequests() []BatchRequest {
+	requests := make([]BatchRequest, 0, len(b.Symbols))
+	for _, symbol := range b.Symbols {
+		requests = append(requests, BatchRequest{Symbol: symbol, Type: BatchBook})
+	}
+	return requests
+}
+
+// CollectBatch sends the books fetched from the batch endpoint, skipping the
+// missing symbols
+func (b *Book) CollectBatch(ctx context.Context, result BatchResult, ch chan<- prometheus.Metric) error {
+	report := ReportFrom(ctx)
+	for _, symbol := range b.Symbols {
+		report.Attempt(symbol)
+		data := result.Get(symbol)
+		if data == nil || data.Book == nil {
+			report.Fail(symbol, BatchEndpoint, result.Missing(symbol, "book"))
+			continue
+		}
+		b.collect(symbol, data.Book.Bids, data.Book.Asks, ch)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	iex "github.com/vglafirov/iexcloud"
+	"github.com/vglafirov/iexcloud_exporter/pkg/iextest"
+)
+
+func TestBook(t *testing.T) {
+	server := httptest.NewServer(iextest.NewServer(iextest.DefaultFixtures()))
+	defer server.Close()
+
+	book := &Book{}
+	if err := book.Configure([]byte(`{"symbols": ["aapl", "msft"], "levels": 2, "depth_bps": [10, 50]}`)); err != nil {
+		t.Fatal(err)
+	}
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(collectorFunc{book, iex.NewClient("test", server.URL+"/stable/")})
+
+	// The MSFT book is empty after the close
+	expected := `
+# HELP iexcloud_book_ask_price Best ask price on IEX.
+# TYPE iexcloud_book_ask_price gauge
+iexcloud_book_ask_price{symbol="aapl"} 261.8
+# HELP iexcloud_book_bid_price Best bid price on IEX.
+# TYPE iexcloud_book_bid_price gauge
+iexcloud_book_bid_price{symbol="aapl"} 261.75
+# HELP iexcloud_book_depth_size Number of shares of the price levels within bps basis points of the mid price.
+# TYPE iexcloud_book_depth_size gauge
+iexcloud_book_depth_size{bps="10",side="ask",symbol="aapl"} 300
+iexcloud_book_depth_size{bps="10",side="bid",symbol="aapl"} 400
+iexcloud_book_depth_size{bps="50",side="ask",symbol="aapl"} 700
+iexcloud_book_depth_size{bps="50",side="bid",symbol="aapl"} 900
+# HELP iexcloud_book_level_size Number of shares at the price level of the book, 1 is the best.
+# TYPE iexcloud_book_level_size gauge
+iexcloud_book_level_size{level="1",side="ask",symbol="aapl"} 200
+iexcloud_book_level_size{level="1",side="bid",symbol="aapl"} 100
+iexcloud_book_level_size{level="2",side="ask",symbol="aapl"} 100
+iexcloud_book_level_size{level="2",side="bid",symbol="aapl"} 300
+# HELP iexcloud_book_mid_price Mean of the best bid and ask prices.
+# TYPE iexcloud_book_mid_price gauge
+iexcloud_book_mid_price{symbol="aapl"} 261.775
+`
+	names := []string{"iexcloud_book_ask_price", "iexcloud_book_bid_price", "iexcloud_book_depth_size", "iexcloud_book_level_size", "iexcloud_book_mid_price"}
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), names...); err != nil {
+		t.Error(err)
+	}
+
+	for params, expected := range map[string]string{
+		`{"symbols": ["aapl"], "levels": -1}`:           "levels: must not be negative",
+		`{"symbols": ["aapl"], "depth_bps": [10, 0]}`:   "depth_bps[1]: must be positive, got 0",
+		`{"symbols": ["aapl"], "depth_bps": [10, -25]}`: "depth_bps[1]: must be positive, got -25",
+		`{"symbols": ["aapl"], "depth_bps": [10, 10]}`:  "depth_bps[1]: duplicate value 10",
+	} {
+		if err := (&Book{}).Configure([]byte(params)); err == nil || err.Error() != expected {
+			t.Errorf("%s: expected %q, got %v", params, expected, err)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	// TODO: Replace with github.com/goinvest/iexcloud once https://github.com/goinvest/iexcloud/issues/41 is closed
+	iex "github.com/vglafirov/iexcloud"
+)
+
+// Collector is a metric group which can be enabled in the config file
+type Collector interface {
+	// Name returns the config key of the metric group
+	Name() string
+	// Configure decodes and validates the metric group parameters from the
+	// config file. Invalid values are reported as config.Error, located
+	// relative to the parameters.
+	Configure(params json.RawMessage) error
+	// Describe sends the descriptors of all the metrics the group can export
+	Describe(ch chan<- *prometheus.Desc)
+	// Collect queries IEX Cloud and sends the resulting metrics. It returns
+	// early once ctx is done. The queried symbols are recorded in the report
+	// carried by ctx.
+	Collect(ctx context.Context, client *iex.Client, ch chan<- prometheus.Metric) error
+}
+
+// RealTimeCollector is implemented by the collectors of real-time market data,
+// which are paused while the market is closed
+type RealTimeCollector interface {
+	Collector
+	// RealTime marks the collector as real-time
+	RealTime()
+}
+
+// FailureCollector is implemented by the collectors whose metrics report the
+// failures of their own requests, e.g. the status group reports IEX Cloud
+// down when it cannot be reached. The metrics they send replace the previous
+// ones even if the collection fails.
+type FailureCollector interface {
+	Collector
+	// ReportsFailures marks the collector as reporting its failures
+	ReportsFailures()
+}
+
+// StatefulCollector is implemented by the collectors which keep state between
+// refreshes, e.g. the intraday group remembers the last bar sent. The state is
+// carried over to the collector of the same group when the config is reloaded.
+type StatefulCollector interface {
+	Collector
+	// Carry copies the state of prev, a collector of the same type, for the
+	// symbols still configured
+	Carry(prev Collector)
+}
+
+// ClientFunc returns an IEX Cloud client authenticated with token whose
+// requests are bound to ctx
+type ClientFunc func(ctx context.Context, token string) *iex.Client
+
+// Factory returns a new, unconfigured collector
+type Factory func() Collector
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a metric group available in the config file under the given
+// name. It is meant to be called from init() and panics on duplicate names.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("collector %q is already registered", name))
+	}
+	factories[name] = factory
+}
+
+// New returns an unconfigured collector for the named metric group
+func New(name string) (Collector, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown metric group %q, available: %s", name, strings.Join(Names(), ", "))
+	}
+	return factory(), nil
+}
+
+// Names returns the sorted names of all registered metric groups
+func Names() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Describe sends the descriptors of every registered metric group
+func Describe(ch chan<- *prometheus.Desc) {
+	for _, name := range Names() {
+		factoriesMu.RLock()
+		factory := factories[name]
+		factoriesMu.RUnlock()
+
+		factory().Describe(ch)
+	}
+}
+
+// fqNameRegexp extracts the metric name from the string of a descriptor, the
+// client library does not expose it otherwise
+var fqNameRegexp = regexp.MustCompile(`fqName: "([^"]*)"`)
+
+// DescName returns the metric name of a descriptor
+func DescName(d *prometheus.Desc) string {
+	m := fqNameRegexp.FindStringSubmatch(d.String())
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// Rejected returns the descriptors of the collector whose metric name keep
+// rejects
+func Rejected(c Collector, keep func(name string) bool) map[*prometheus.Desc]bool {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+	rejected := make(map[*prometheus.Desc]bool)
+	for d := range ch {
+		if !keep(DescName(d)) {
+			rejected[d] = true
+		}
+	}
+	return rejected
+}
@@ -0,0 +1,176 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	iex "github.com/vglafirov/iexcloud"
+	"github.com/vglafirov/iexcloud_exporter/pkg/iextest"
+	"github.com/vglafirov/iexcloud_exporter/pkg/transport"
+)
+
+// collectorFunc adapts a metric group to a prometheus.Collector
+type collectorFunc struct {
+	Collector
+	client *iex.Client
+}
+
+func (c collectorFunc) Collect(ch chan<- prometheus.Metric) {
+	c.Collector.Collect(context.Background(), c.client, ch)
+}
+
+// collect runs a refresh of the metric group
+func collect(c Collector, client *iex.Client) ([]prometheus.Metric, *Report, error) {
+	report := NewReport()
+	ch := make(chan prometheus.Metric)
+	done := make(chan error)
+	go func() {
+		err := c.Collect(WithReport(context.Background(), report), client, ch)
+		close(ch)
+		done <- err
+	}()
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	return metrics, report, <-done
+}
+
+// collectBatch runs a refresh of the metric group from the batch endpoint
+func collectBatch(c BatchCollector, client *iex.Client) ([]prometheus.Metric, *Report, error) {
+	result, err := FetchBatch(context.Background(), client, c.BatchRequests())
+	if err != nil {
+		return nil, nil, err
+	}
+	report := NewReport()
+	ch := make(chan prometheus.Metric)
+	done := make(chan error)
+	go func() {
+		err := c.CollectBatch(WithReport(context.Background(), report), result, ch)
+		close(ch)
+		done <- err
+	}()
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	return metrics, report, <-done
+}
+
+func TestCollectors(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  string
+		fault   *iextest.Fault
+		metrics int
+		failed  int
+		err     bool
+	}{
+		{name: "price", params: `{"symbols": ["aapl", "msft"]}`, metrics: 2},
+		{name: "keystats", params: `{"symbols": ["aapl", "msft"]}`, metrics: 2 * 27},
+		{name: "dividends", params: `{"symbols": ["aapl", "msft"], "range": ["1y", "5y"]}`, metrics: 2 + 3 + 1 + 2},
+		{name: "price", params: `{"symbols": ["aapl", "goog"]}`, metrics: 1, failed: 1},
+		{name: "quote", params: `{"symbols": ["aapl", "msft"]}`, metrics: (1 + 12) + (1 + 12)},
+		{name: "ohlc", params: `{"symbols": ["aapl", "msft"]}`, metrics: 4 + 6},
+		{name: "previous", params: `{"symbols": ["aapl", "msft"]}`, metrics: 2 * 5},
+		{name: "book", params: `{"symbols": ["aapl", "msft"]}`, metrics: 4 + 2 + 2*2*3 + 2*2},
+		{
+			name:    "price",
+			params:  `{"symbols": ["aapl", "msft"]}`,
+			fault:   &iextest.Fault{Path: "/stock/msft", Status: http.StatusInternalServerError},
+			metrics: 1,
+			failed:  1,
+		},
+		{
+			name:   "keystats",
+			params: `{"symbols": ["aapl", "msft"]}`,
+			fault:  &iextest.Fault{Status: http.StatusPaymentRequired},
+			failed: 2,
+			err:    true,
+		},
+		{
+			name:   "dividends",
+			params: `{"symbols": ["aapl"], "range": ["1y"]}`,
+			fault:  &iextest.Fault{Status: http.StatusTooManyRequests},
+			failed: 1,
+			err:    true,
+		},
+	}
+
+	for _, test := range tests {
+		s := iextest.NewServer(iextest.DefaultFixtures())
+		server := httptest.NewServer(s)
+		// As in the exporter, the errors are *APIError wrapped in a *url.Error
+		client := iex.NewClient("test", server.URL+"/stable/",
+			iex.WithHTTPClient(&http.Client{Transport: transport.NewErrors(nil)}))
+		if test.fault != nil {
+			s.Inject(*test.fault)
+		}
+
+		c, err := New(test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Configure([]byte(test.params)); err != nil {
+			t.Fatal(err)
+		}
+
+		metrics, report, err := collect(c, client)
+		if err != nil {
+			t.Errorf("%s %s: %s", test.name, test.params, err)
+		}
+		if len(metrics) != test.metrics {
+			t.Errorf("%s %s: expected %d metrics, got %d", test.name, test.params, test.metrics, len(metrics))
+		}
+		if report.Failed() != test.failed {
+			t.Errorf("%s %s: expected %d failed symbols, got %d", test.name, test.params, test.failed, report.Failed())
+		}
+		for _, f := range report.Failures() {
+			if strings.Contains(f.Err.Error(), "token=test") {
+				t.Errorf("%s %s: expected the token to be redacted, got %s", test.name, test.params, f.Err)
+			}
+		}
+
+		// The failures of a batch are the failures of all its symbols
+		metrics, report, err = collectBatch(c.(BatchCollector), client)
+		switch {
+		case test.err && err == nil:
+			t.Errorf("%s %s: expected the batch request to fail", test.name, test.params)
+		case !test.err && err != nil:
+			t.Errorf("%s %s: batch: %s", test.name, test.params, err)
+		case !test.err && test.fault == nil && len(metrics) != test.metrics:
+			t.Errorf("%s %s: batch: expected %d metrics, got %d", test.name, test.params, test.metrics, len(metrics))
+		case !test.err && test.fault == nil && report.Failed() != test.failed:
+			t.Errorf("%s %s: batch: expected %d failed symbols, got %d", test.name, test.params, test.failed, report.Failed())
+		}
+		server.Close()
+	}
+}
@@ -25,6 +25,10 @@ SOFTWARE.
 package model
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 
@@ -53,70 +57,142 @@ var (
 
 // Dividend data
 type Dividend struct {
-	Client    *iex.Client
-	Symbols   []string
-	Range     []iex.PathRange
-	Dividends []iex.Dividend
+	Symbols []string        `json:"symbols"`
+	Range   []iex.PathRange `json:"range"`
 }
 
-// API Dividend API call
-func (d *Dividend) API(ch chan<- prometheus.Metric) error {
+func init() {
+	Register("dividends", func() Collector { return &Dividend{} })
+}
+
+// Name returns the config key of the dividends group
+func (d *Dividend) Name() string {
+	return "dividends"
+}
+
+// Configure decodes and validates the dividends group parameters
+func (d *Dividend) Configure(params json.RawMessage) error {
+	var p struct {
+		Symbols []string `json:"symbols"`
+		Range   []string `json:"range"`
+	}
+	if err := config.Decode(params, &p); err != nil {
+		return err
+	}
+	if err := config.ValidateSymbols(p.Symbols); err != nil {
+		return err
+	}
+	if len(p.Range) == 0 {
+		return config.WrapError("range", errors.New("at least one range is required"))
+	}
+
+	d.Symbols, d.Range = p.Symbols, make([]iex.PathRange, len(p.Range))
+	for i, r := range p.Range {
+		pathRange, ok := iex.PathRanges[r]
+		if !ok {
+			return config.WrapError(fmt.Sprintf("range[%d]", i), fmt.Errorf("invalid range %q", r))
+		}
+		d.Range[i] = pathRange
+	}
+	return nil
+}
+
+// Describe sends the dividends metric descriptors
+func (d *Dividend) Describe(ch chan<- *prometheus.Desc) {
+	ch <- DividendsMetric
+}
+
+// Collect Dividend API call. Symbols which cannot be collected are recorded in
+// the report and skipped.
+func (d *Dividend) Collect(ctx context.Context, client *iex.Client, ch chan<- prometheus.Metric) error {
+	report := ReportFrom(ctx)
 	for _, symbol := range d.Symbols {
 		for _, pathRange := range d.Range {
-			div, err := d.Client.Dividends(symbol, pathRange)
-			if err != nil {
+			if err := ctx.Err(); err != nil {
 				return err
 			}
-			d.Dividends = div
-			for _, dividend := range d.Dividends {
-				var amount float64
-				var err error
-				if amount, err = strconv.ParseFloat(dividend.Amount, 64); err != nil {
-					return err
-				}
-				var exdate, paymentDate, recordDate, declaredDate []byte
-				if exdate, err = dividend.ExDate.MarshalJSON(); err != nil {
-					return err
-				}
-				if paymentDate, err = dividend.PaymentDate.MarshalJSON(); err != nil {
-					return err
-				}
-				if recordDate, err = dividend.RecordDate.MarshalJSON(); err != nil {
-					return err
-				}
-				if declaredDate, err = dividend.DeclaredDate.MarshalJSON(); err != nil {
-					return err
-				}
-				ch <- prometheus.MustNewConstMetric(
-					DividendsMetric,
-					prometheus.GaugeValue,
-					amount,
-					symbol,
-					pathRange.String(),
-					strings.Trim(string(exdate), `"`),
-					strings.Trim(string(paymentDate), `"`),
-					strings.Trim(string(recordDate), `"`),
-					strings.Trim(string(declaredDate), `"`),
-				)
+			report.Attempt(symbol)
+			endpoint := "stock/" + symbol + "/dividends/" + iex.PathRangeJSON[pathRange]
+			dividends, err := client.Dividends(symbol, pathRange)
+			if err != nil {
+				report.Fail(symbol, endpoint, err)
+				continue
+			}
+			if err := d.collect(symbol, pathRange, dividends, ch); err != nil {
+				report.Fail(symbol, endpoint, err)
 			}
 		}
 	}
 	return nil
 }
 
-// SetDividendsParams Converts map of unknown parameters to symbols
-func SetDividendsParams(d *Dividend, p interface{}) error {
-	params := p.(map[string]interface{})
-	s := params["symbols"].([]interface{})
-	r := params["range"].([]interface{})
-	for _, symbol := range s {
-		d.Symbols = append(d.Symbols, symbol.(string))
-	}
-	d.Range = make([]iex.PathRange, len(r))
-	for i, dividendRange := range r {
-		if err := d.Range[i].Set(dividendRange.(string)); err != nil {
+// collect sends the dividends of the symbol for the given range
+func (d *Dividend) collect(symbol string, pathRange iex.PathRange, dividends []iex.Dividend, ch chan<- prometheus.Metric) error {
+	for _, dividend := range dividends {
+		var amount float64
+		var err error
+		if amount, err = strconv.ParseFloat(dividend.Amount, 64); err != nil {
+			return err
+		}
+		var exdate, paymentDate, recordDate, declaredDate []byte
+		if exdate, err = dividend.ExDate.MarshalJSON(); err != nil {
 			return err
 		}
+		if paymentDate, err = dividend.PaymentDate.MarshalJSON(); err != nil {
+			return err
+		}
+		if recordDate, err = dividend.RecordDate.MarshalJSON(); err != nil {
+			return err
+		}
+		if declaredDate, err = dividend.DeclaredDate.MarshalJSON(); err != nil {
+			return err
+		}
+		ch <- prometheus.MustNewConstMetric(
+			DividendsMetric,
+			prometheus.GaugeValue,
+			amount,
+			symbol,
+			pathRange.String(),
+			strings.Trim(string(exdate), `"`),
+			strings.Trim(string(paymentDate), `"`),
+			strings.Trim(string(recordDate), `"`),
+			strings.Trim(string(declaredDate), `"`),
+		)
+	}
+	return nil
+}
+
+// BatchRequests returns the dividends to fetch from the batch endpoint
+func (d *Dividend) BatchRequests() []BatchRequest {
+	requests := make([]BatchRequest, 0, len(d.Symbols)*len(d.Range))
+	for _, symbol := range d.Symbols {
+		for _, pathRange := range d.Range {
+			requests = append(requests, BatchRequest{Symbol: symbol, Type: BatchDividends, Range: pathRange})
+		}
+	}
+	return requests
+}
+
+// CollectBatch sends the dividends fetched from the batch endpoint, skipping
+// the missing symbols
+func (d *Dividend) CollectBatch(ctx context.Context, result BatchResult, ch chan<- prometheus.Metric) error {
+	report := ReportFrom(ctx)
+	for _, symbol := range d.Symbols {
+		for _, pathRange := range d.Range {
+			report.Attempt(symbol)
+			var dividends []iex.Dividend
+			data := result.Get(symbol)
+			if data != nil {
+				dividends = data.Dividends[pathRange]
+			}
+			if dividends == nil {
+				report.Fail(symbol, BatchEndpoint, result.Missing(symbol, "dividends"))
+				continue
+			}
+			if err := d.collect(symbol, pathRange, dividends, ch); err != nil {
+				report.Fail(symbol, BatchEndpoint, err)
+			}
+		}
 	}
 	return nil
 }
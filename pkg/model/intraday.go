@@ -0,0 +1,261 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	// TODO: Replace with github.com/goinvest/iexcloud once https://github.com/goinvest/iexcloud/issues/41 is closed
+	iex "github.com/vglafirov/iexcloud"
+	"github.com/vglafirov/iexcloud_exporter/pkg/calendar"
+	"github.com/vglafirov/iexcloud_exporter/pkg/config"
+)
+
+func newIntradayDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "intraday", name),
+		help,
+		[]string{"symbol"},
+		nil,
+	)
+}
+
+// Prometheus metric definitions of the intraday bars
+var (
+	IntradayOpen     = newIntradayDesc("open", "Open price of the intraday bar.")
+	IntradayHigh     = newIntradayDesc("high", "Highest price of the intraday bar.")
+	IntradayLow      = newIntradayDesc("low", "Lowest price of the intraday bar.")
+	IntradayClose    = newIntradayDesc("close", "Close price of the intraday bar.")
+	IntradayVolume   = newIntradayDesc("volume", "Volume of the intraday bar.")
+	IntradayNotional = newIntradayDesc("notional", "Dollar value traded during the intraday bar.")
+	IntradayTrades   = newIntradayDesc("trades", "Number of trades during the intraday bar.")
+	IntradaySkipped  = newIntradayDesc("skipped_bars_total", "Number of complete intraday bars which were not sent because a newer one was.")
+)
+
+// Intraday minute bars of the current trading day. The newest complete bar is
+// sent once, stamped with its start time.
+type Intraday struct {
+	Symbols []string
+	Options iex.IntradayHistoricalOptions
+
+	location *time.Location
+	// now returns the current time, replaced by the tests
+	now func() time.Time
+
+	mtx sync.Mutex
+	// last start time of the bars sent, by symbol
+	last map[string]time.Time
+	// skipped number of complete bars never sent, by symbol
+	skipped map[string]float64
+}
+
+func init() {
+	Register("intraday", func() Collector { return &Intraday{} })
+}
+
+// Name returns the config key of the intraday group
+func (i *Intraday) Name() string {
+	return "intraday"
+}
+
+// RealTime marks the intraday group as real-time
+func (i *Intraday) RealTime() {}
+
+// Configure decodes and validates the intraday group parameters
+func (i *Intraday) Configure(params json.RawMessage) error {
+	var p struct {
+		Symbols       []string `json:"symbols"`
+		ChartInterval int      `json:"chart_interval"`
+		ChartLast     int      `json:"chart_last"`
+		ChartIEXOnly  bool     `json:"chart_iex_only"`
+	}
+	if err := config.Decode(params, &p); err != nil {
+		return err
+	}
+	if err := config.ValidateSymbols(p.Symbols); err != nil {
+		return err
+	}
+	if p.ChartInterval < 0 {
+		return config.WrapError("chart_interval", errors.New("must not be negative"))
+	}
+	if p.ChartLast < 0 {
+		return config.WrapError("chart_last", errors.New("must not be negative"))
+	}
+	location, err := time.LoadLocation(calendar.DefaultTimezone)
+	if err != nil {
+		return err
+	}
+
+	i.Symbols = p.Symbols
+	i.Options = iex.IntradayHistoricalOptions{
+		ChartInterval: p.ChartInterval,
+		ChartLast:     p.ChartLast,
+		ChartIEXOnly:  p.ChartIEXOnly,
+	}
+	i.location, i.now = location, time.Now
+	i.last = make(map[string]time.Time)
+	i.skipped = make(map[string]float64)
+	return nil
+}
+
+// Carry copies the last bar sent and the skipped bars of the symbols still
+// configured from the previous intraday group
+func (i *Intraday) Carry(prev Collector) {
+	old, ok := prev.(*Intraday)
+	if !ok || old == i {
+		return
+	}
+	old.mtx.Lock()
+	defer old.mtx.Unlock()
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	for _, symbol := range i.Symbols {
+		if last, ok := old.last[symbol]; ok {
+			i.last[symbol] = last
+			i.skipped[symbol] = old.skipped[symbol]
+		}
+	}
+}
+
+// Describe sends the intraday metric descriptors
+func (i *Intraday) Describe(ch chan<- *prometheus.Desc) {
+	ch <- IntradayOpen
+	ch <- IntradayHigh
+	ch <- IntradayLow
+	ch <- IntradayClose
+	ch <- IntradayVolume
+	ch <- IntradayNotional
+	ch <- IntradayTrades
+	ch <- IntradaySkipped
+}
+
+// Collect IntradayHistoricalPrices API call. Symbols which cannot be collected
+// are recorded in the report and skipped.
+func (i *Intraday) Collect(ctx context.Context, client *iex.Client, ch chan<- prometheus.Metric) error {
+	report := ReportFrom(ctx)
+	for _, symbol := range i.Symbols {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		report.Attempt(symbol)
+		options := i.Options
+		bars, err := client.IntradayHistoricalPrices(symbol, &options)
+		if err != nil {
+			report.Fail(symbol, "stock/"+symbol+"/chart/1d", err)
+			continue
+		}
+		if err := i.collect(symbol, bars, ch); err != nil {
+			report.Fail(symbol, "stock/"+symbol+"/chart/1d", err)
+		}
+	}
+	return nil
+}
+
+// collect sends the newest complete bar which was not sent yet. A scrape can
+// only hold one sample per series, so a single bar is sent per refresh: the
+// older complete bars are counted as skipped, except at the first refresh.
+func (i *Intraday) collect(symbol string, bars []iex.IntradayHistoricalDataPoint, ch chan<- prometheus.Metric) error {
+	interval := time.Duration(i.Options.ChartInterval) * time.Minute
+	if interval == 0 {
+		interval = time.Minute
+	}
+	now := i.now()
+
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	last, sent := i.last[symbol]
+	var (
+		next      *iex.IntradayHistoricalDataPoint
+		nextStart time.Time
+		complete  int
+	)
+	for j := range bars {
+		start, err := time.ParseInLocation("2006-01-02 15:04", bars[j].Date+" "+bars[j].Minute, i.location)
+		if err != nil {
+			return fmt.Errorf("invalid bar time %q %q", bars[j].Date, bars[j].Minute)
+		}
+		// The bar of the current minute is still updated by IEX Cloud
+		if (sent && !start.After(last)) || start.Add(interval).After(now) {
+			continue
+		}
+		complete++
+		if next == nil || start.After(nextStart) {
+			next, nextStart = &bars[j], start
+		}
+	}
+	if next != nil {
+		if sent {
+			i.skipped[symbol] += float64(complete - 1)
+		}
+		i.last[symbol] = nextStart
+		for _, m := range i.values(*next) {
+			ch <- prometheus.NewMetricWithTimestamp(nextStart,
+				prometheus.MustNewConstMetric(m.desc, prometheus.GaugeValue, m.value, symbol))
+		}
+		sent = true
+	}
+	if sent {
+		ch <- prometheus.MustNewConstMetric(IntradaySkipped, prometheus.CounterValue, i.skipped[symbol], symbol)
+	}
+	return nil
+}
+
+type intradayValue struct {
+	desc  *prometheus.Desc
+	value float64
+}
+
+// values returns the market-wide values of the bar, or the IEX ones with
+// chart_iex_only. The prices are left out of the bars without trades, IEX
+// Cloud sends them as null.
+func (i *Intraday) values(bar iex.IntradayHistoricalDataPoint) []intradayValue {
+	o, h, l, c := bar.MarketOpen, bar.MarketHigh, bar.MarketLow, bar.MarketClose
+	volume, notional, trades := bar.MarketVolume, bar.MarketNotional, bar.MarketNumberOfTrades
+	if i.Options.ChartIEXOnly {
+		o, h, l, c = bar.Open, bar.High, bar.Low, bar.Close
+		volume, notional, trades = bar.Volume, bar.Notional, bar.NumberOfTrades
+	}
+
+	values := []intradayValue{
+		{IntradayVolume, float64(volume)},
+		{IntradayNotional, notional},
+		{IntradayTrades, float64(trades)},
+	}
+	if trades > 0 {
+		values = append(values,
+			intradayValue{IntradayOpen, o},
+			intradayValue{IntradayHigh, h},
+			intradayValue{IntradayLow, l},
+			intradayValue{IntradayClose, c},
+		)
+	}
+	return values
+}
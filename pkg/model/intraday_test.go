@@ -0,0 +1,138 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	iex "github.com/vglafirov/iexcloud"
+	"github.com/vglafirov/iexcloud_exporter/pkg/iextest"
+)
+
+func TestIntraday(t *testing.T) {
+	server := httptest.NewServer(iextest.NewServer(iextest.DefaultFixtures()))
+	defer server.Close()
+	client := iex.NewClient("test", server.URL+"/stable/")
+
+	intraday := &Intraday{}
+	if err := intraday.Configure([]byte(`{"symbols": ["aapl"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	var now time.Time
+	intraday.now = func() time.Time { return now }
+
+	// bars returns the close of the bars sent, by start time
+	var skipped float64
+	bars := func() map[string]float64 {
+		metrics, report, err := collect(intraday, client)
+		if err != nil || report.Failed() != 0 {
+			t.Fatalf("collect: %v, %d failed symbols", err, report.Failed())
+		}
+		closes := make(map[string]float64)
+		for _, m := range metrics {
+			var metric dto.Metric
+			if err := m.Write(&metric); err != nil {
+				t.Fatal(err)
+			}
+			if m.Desc() == IntradaySkipped {
+				skipped = metric.GetCounter().GetValue()
+				continue
+			}
+			start := time.Unix(0, metric.GetTimestampMs()*int64(time.Millisecond)).In(intraday.location).Format("15:04")
+			if _, ok := closes[start]; !ok {
+				closes[start] = -1
+			}
+			if strings.Contains(m.Desc().String(), `"iexcloud_intraday_close"`) {
+				closes[start] = metric.GetGauge().GetValue()
+			}
+		}
+		return closes
+	}
+
+	// The bar of the current minute is held back and the bars without trades
+	// have no prices
+	now = time.Date(2019, 11, 11, 9, 31, 30, 0, intraday.location)
+	if got := bars(); len(got) != 1 || got["09:30"] != 260.8 {
+		t.Errorf("expected the 09:30 bar, got %v", got)
+	}
+	if got := bars(); len(got) != 0 {
+		t.Errorf("expected the bars to be sent once, got %v", got)
+	}
+	now = now.Add(time.Minute)
+	if got := bars(); len(got) != 1 || got["09:31"] != -1 {
+		t.Errorf("expected the 09:31 bar, got %v", got)
+	}
+	now = now.Add(time.Minute)
+	if got := bars(); len(got) != 1 || got["09:32"] != 261.01 {
+		t.Errorf("expected the 09:32 bar, got %v", got)
+	}
+	if got := bars(); len(got) != 0 || skipped != 0 {
+		t.Errorf("expected no bar and no skipped bar, got %v and %g skipped", got, skipped)
+	}
+
+	// Only the newest of the bars completed since the last refresh is sent
+	if err := intraday.Configure([]byte(`{"symbols": ["aapl"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	intraday.now = func() time.Time { return now }
+	now = time.Date(2019, 11, 11, 9, 31, 30, 0, intraday.location)
+	if got := bars(); len(got) != 1 || got["09:30"] != 260.8 {
+		t.Errorf("expected the 09:30 bar, got %v", got)
+	}
+	now = now.Add(2 * time.Minute)
+	if got := bars(); len(got) != 1 || got["09:32"] != 261.01 || skipped != 1 {
+		t.Errorf("expected the 09:32 bar and the 09:31 one skipped, got %v and %g skipped", got, skipped)
+	}
+
+	// A reload keeps the bars sent and the skipped bars
+	reloaded := &Intraday{}
+	if err := reloaded.Configure([]byte(`{"symbols": ["aapl"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	reloaded.Carry(intraday)
+	intraday = reloaded
+	intraday.now = func() time.Time { return now }
+	if got := bars(); len(got) != 0 || skipped != 1 {
+		t.Errorf("expected no bar and 1 skipped after a reload, got %v and %g skipped", got, skipped)
+	}
+
+	// The first refresh starts with the last complete bar
+	if err := intraday.Configure([]byte(`{"symbols": ["aapl"], "chart_last": 1, "chart_iex_only": true}`)); err != nil {
+		t.Fatal(err)
+	}
+	intraday.now = func() time.Time { return now }
+	if got := bars(); got["09:32"] != 261 {
+		t.Errorf("expected the IEX close of the 09:32 bar, got %v", got)
+	}
+
+	if err := intraday.Configure([]byte(`{"symbols": ["aapl"], "chart_interval": -1}`)); err == nil ||
+		err.Error() != "chart_interval: must not be negative" {
+		t.Errorf("expected a chart_interval error, got %v", err)
+	}
+}
@@ -25,6 +25,8 @@ SOFTWARE.
 package model
 
 import (
+	"context"
+	"encoding/json"
 	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -311,9 +313,11 @@ var (
 
 // KeyStats data
 type KeyStats struct {
-	Client   *iex.Client
-	Symbols  []string
-	KeyStats iex.KeyStats
+	Symbols []string `json:"symbols"`
+}
+
+func init() {
+	Register("keystats", func() Collector { return &KeyStats{} })
 }
 
 func toUnknown(s string) string {
@@ -323,122 +327,195 @@ func toUnknown(s string) string {
 	return s
 }
 
-// API Dividend API call
-func (s *KeyStats) API(ch chan<- prometheus.Metric) error {
-	for _, symbol := range s.Symbols {
-		var err error
-		s.KeyStats, err = s.Client.KeyStats(symbol)
-		if err != nil {
-			return err
+// Name returns the config key of the keystats group
+func (s *KeyStats) Name() string {
+	return "keystats"
+}
 
-		}
-		ch <- prometheus.MustNewConstMetric(
-			MarketcapStatsMetric, prometheus.GaugeValue, s.KeyStats.MarketCap, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			Week52High, prometheus.GaugeValue, s.KeyStats.Week52High, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			Week52Low, prometheus.GaugeValue, s.KeyStats.Week52Low, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			Week52Change, prometheus.GaugeValue, s.KeyStats.Week52Change, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			SharesOutstanding, prometheus.GaugeValue, s.KeyStats.SharesOutstanding, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			Avg30Volume, prometheus.GaugeValue, s.KeyStats.Avg30Volume, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			Avg10Volume, prometheus.GaugeValue, s.KeyStats.Avg10Volume, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			Float, prometheus.GaugeValue, s.KeyStats.Float, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			Employees, prometheus.GaugeValue, float64(s.KeyStats.Employees), symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			TTMEPS, prometheus.GaugeValue, s.KeyStats.TTMEPS, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			TTMDividendRate, prometheus.GaugeValue, s.KeyStats.TTMDividendRate, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			DividendYield, prometheus.GaugeValue, s.KeyStats.DividendYield, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			PERatio, prometheus.GaugeValue, s.KeyStats.PERatio, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			Beta, prometheus.GaugeValue, s.KeyStats.Beta, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			Day200MovingAvg, prometheus.GaugeValue, s.KeyStats.Day200MovingAvg, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			Day50MovingAvg, prometheus.GaugeValue, s.KeyStats.Day50MovingAvg, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			MaxChangePercent, prometheus.GaugeValue, s.KeyStats.MaxChangePercent, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			Year5ChangePercent, prometheus.GaugeValue, s.KeyStats.Year5ChangePercent, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			Year2ChangePercent, prometheus.GaugeValue, s.KeyStats.Year2ChangePercent, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			Year1ChangePercent, prometheus.GaugeValue, s.KeyStats.Year1ChangePercent, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			YTDChangePercent, prometheus.GaugeValue, s.KeyStats.YTDChangePercent, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			Month6ChangePercent, prometheus.GaugeValue, s.KeyStats.Month6ChangePercent, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			Month3ChangePercent, prometheus.GaugeValue, s.KeyStats.Month3ChangePercent, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			Month1ChangePercent, prometheus.GaugeValue, s.KeyStats.Month1ChangePercent, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			Day30ChangePercent, prometheus.GaugeValue, s.KeyStats.Day30ChangePercent, symbol,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			Day5ChangePercent, prometheus.GaugeValue, s.KeyStats.Day5ChangePercent, symbol,
-		)
-		nextDividendDate, err := s.KeyStats.NextDividendDate.MarshalJSON()
-		if err != nil {
+// Configure decodes and validates the keystats group parameters
+func (s *KeyStats) Configure(params json.RawMessage) error {
+	if err := config.Decode(params, s); err != nil {
+		return err
+	}
+	return config.ValidateSymbols(s.Symbols)
+}
+
+// Describe sends the keystats metric descriptors
+func (s *KeyStats) Describe(ch chan<- *prometheus.Desc) {
+	ch <- MarketcapStatsMetric
+	ch <- Week52High
+	ch <- Week52Low
+	ch <- Week52Change
+	ch <- SharesOutstanding
+	ch <- Avg30Volume
+	ch <- Avg10Volume
+	ch <- Float
+	ch <- Employees
+	ch <- TTMEPS
+	ch <- TTMDividendRate
+	ch <- DividendYield
+	ch <- PERatio
+	ch <- Beta
+	ch <- Day200MovingAvg
+	ch <- Day50MovingAvg
+	ch <- MaxChangePercent
+	ch <- Year5ChangePercent
+	ch <- Year2ChangePercent
+	ch <- Year1ChangePercent
+	ch <- YTDChangePercent
+	ch <- Month6ChangePercent
+	ch <- Month3ChangePercent
+	ch <- Month1ChangePercent
+	ch <- Day30ChangePercent
+	ch <- Day5ChangePercent
+	ch <- KeyStatDates
+}
+
+// Collect Key Stats API call. Symbols which cannot be collected are recorded
+// in the report and skipped.
+func (s *KeyStats) Collect(ctx context.Context, client *iex.Client, ch chan<- prometheus.Metric) error {
+	report := ReportFrom(ctx)
+	for _, symbol := range s.Symbols {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
-		exDividendDate, err := s.KeyStats.ExDividendDate.MarshalJSON()
+		report.Attempt(symbol)
+		stats, err := client.KeyStats(symbol)
 		if err != nil {
-			return err
+			report.Fail(symbol, "stock/"+symbol+"/stats", err)
+			continue
 		}
-		nextEarningsDate, err := s.KeyStats.NextEarningsDate.MarshalJSON()
-		if err != nil {
-			return err
+		if err := s.collect(symbol, stats, ch); err != nil {
+			report.Fail(symbol, "stock/"+symbol+"/stats", err)
 		}
-		ch <- prometheus.MustNewConstMetric(
-			KeyStatDates, prometheus.GaugeValue, 1, symbol,
-			toUnknown(strings.Trim(string(nextDividendDate), `"`)),
-			toUnknown(strings.Trim(string(exDividendDate), `"`)),
-			toUnknown(strings.Trim(string(nextEarningsDate), `"`)),
-		)
+	}
+	return nil
+}
 
+// collect sends the key stats of the symbol
+func (s *KeyStats) collect(symbol string, stats iex.KeyStats, ch chan<- prometheus.Metric) error {
+	ch <- prometheus.MustNewConstMetric(
+		MarketcapStatsMetric, prometheus.GaugeValue, stats.MarketCap, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		Week52High, prometheus.GaugeValue, stats.Week52High, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		Week52Low, prometheus.GaugeValue, stats.Week52Low, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		Week52Change, prometheus.GaugeValue, stats.Week52Change, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		SharesOutstanding, prometheus.GaugeValue, stats.SharesOutstanding, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		Avg30Volume, prometheus.GaugeValue, stats.Avg30Volume, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		Avg10Volume, prometheus.GaugeValue, stats.Avg10Volume, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		Float, prometheus.GaugeValue, stats.Float, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		Employees, prometheus.GaugeValue, float64(stats.Employees), symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		TTMEPS, prometheus.GaugeValue, stats.TTMEPS, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		TTMDividendRate, prometheus.GaugeValue, stats.TTMDividendRate, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		DividendYield, prometheus.GaugeValue, stats.DividendYield, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		PERatio, prometheus.GaugeValue, stats.PERatio, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		Beta, prometheus.GaugeValue, stats.Beta, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		Day200MovingAvg, prometheus.GaugeValue, stats.Day200MovingAvg, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		Day50MovingAvg, prometheus.GaugeValue, stats.Day50MovingAvg, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		MaxChangePercent, prometheus.GaugeValue, stats.MaxChangePercent, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		Year5ChangePercent, prometheus.GaugeValue, stats.Year5ChangePercent, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		Year2ChangePercent, prometheus.GaugeValue, stats.Year2ChangePercent, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		Year1ChangePercent, prometheus.GaugeValue, stats.Year1ChangePercent, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		YTDChangePercent, prometheus.GaugeValue, stats.YTDChangePercent, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		Month6ChangePercent, prometheus.GaugeValue, stats.Month6ChangePercent, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		Month3ChangePercent, prometheus.GaugeValue, stats.Month3ChangePercent, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		Month1ChangePercent, prometheus.GaugeValue, stats.Month1ChangePercent, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		Day30ChangePercent, prometheus.GaugeValue, stats.Day30ChangePercent, symbol,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		Day5ChangePercent, prometheus.GaugeValue, stats.Day5ChangePercent, symbol,
+	)
+	nextDividendDate, err := stats.NextDividendDate.MarshalJSON()
+	if err != nil {
+		return err
 	}
+	exDividendDate, err := stats.ExDividendDate.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	nextEarningsDate, err := stats.NextEarningsDate.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	ch <- prometheus.MustNewConstMetric(
+		KeyStatDates, prometheus.GaugeValue, 1, symbol,
+		toUnknown(strings.Trim(string(nextDividendDate), `"`)),
+		toUnknown(strings.Trim(string(exDividendDate), `"`)),
+		toUnknown(strings.Trim(string(nextEarningsDate), `"`)),
+	)
 	return nil
 }
 
-// SetKeyStatsParams Converts map of unknown parameters to symbols
-func SetKeyStatsParams(stats *KeyStats, p interface{}) error {
-	params := p.(map[string]interface{})
-	s := params["symbols"].([]interface{})
-	for _, symbol := range s {
-		stats.Symbols = append(stats.Symbols, symbol.(string))
+// BatchRequests returns the key stats to fetch from the batch endpoint
+func (s *KeyStats) BatchRequests() []BatchRequest {
+	requests := make([]BatchRequest, 0, len(s.Symbols))
+	for _, symbol := range s.Symbols {
+		requests = append(requests, BatchRequest{Symbol: symbol, Type: BatchStats})
+	}
+	return requests
+}
+
+// CollectBatch sends the key stats fetched from the batch endpoint, skipping
+// the missing symbols
+func (s *KeyStats) CollectBatch(ctx context.Context, result BatchResult, ch chan<- prometheus.Metric) error {
+	report := ReportFrom(ctx)
+	for _, symbol := range s.Symbols {
+		report.Attempt(symbol)
+		data := result.Get(symbol)
+		if data == nil || data.Stats == nil {
+			report.Fail(symbol, BatchEndpoint, result.Missing(symbol, "key stats"))
+			continue
+		}
+		if err := s.collect(symbol, *data.Stats, ch); err != nil {
+			report.Fail(symbol, BatchEndpoint, err)
+		}
 	}
 	return nil
 }
@@ -0,0 +1,269 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	// TODO: Replace with github.com/goinvest/iexcloud once https://github.com/goinvest/iexcloud/issues/41 is closed
+	iex "github.com/vglafirov/iexcloud"
+	"github.com/vglafirov/iexcloud_exporter/pkg/config"
+)
+
+// Sessions of the OHLC metrics
+const (
+	SessionToday    = "today"
+	SessionPrevious = "previous"
+)
+
+// OHLCData ohlc endpoint response. The official open and close are null until
+// the exchange publishes them.
+type OHLCData struct {
+	Open  *OHLCPrice `json:"open"`
+	Close *OHLCPrice `json:"close"`
+	High  *float64   `json:"high"`
+	Low   *float64   `json:"low"`
+}
+
+// OHLCPrice official open or close price, with its time in milliseconds
+type OHLCPrice struct {
+	Price *float64 `json:"price"`
+	Time  *int64   `json:"time"`
+}
+
+func newOHLCDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "ohlc", name),
+		help,
+		[]string{"symbol", "session"},
+		nil,
+	)
+}
+
+// Prometheus metric definitions shared by the ohlc and previous groups
+var (
+	OHLCOpen           = newOHLCDesc("open", "Official open price of the session.")
+	OHLCHigh           = newOHLCDesc("high", "Highest price of the session.")
+	OHLCLow            = newOHLCDesc("low", "Lowest price of the session.")
+	OHLCClose          = newOHLCDesc("close", "Official close price of the session.")
+	OHLCVolume         = newOHLCDesc("volume", "Volume of the session.")
+	OHLCOpenTimestamp  = newOHLCDesc("open_timestamp_seconds", "Unix time of the official open price.")
+	OHLCCloseTimestamp = newOHLCDesc("close_timestamp_seconds", "Unix time of the official close price.")
+)
+
+// OHLC official open and close and range of the current trading day
+type OHLC struct {
+	Symbols []string `json:"symbols"`
+}
+
+// Previous open, high, low, close and volume of the previous trading day
+type Previous struct {
+	Symbols []string `json:"symbols"`
+}
+
+func init() {
+	Register("ohlc", func() Collector { return &OHLC{} })
+	Register("previous", func() Collector { return &Previous{} })
+}
+
+// Name returns the config key of the ohlc group
+func (o *OHLC) Name() string {
+	return "ohlc"
+}
+
+// RealTime marks the ohlc group as real-time
+func (o *OHLC) RealTime() {}
+
+// Configure decodes and validates the ohlc group parameters
+func (o *OHLC) Configure(params json.RawMessage) error {
+	if err := config.Decode(params, o); err != nil {
+		return err
+	}
+	return config.ValidateSymbols(o.Symbols)
+}
+
+// Describe sends the ohlc metric descriptors. The ohlc endpoint does not
+// report the volume.
+func (o *OHLC) Describe(ch chan<- *prometheus.Desc) {
+	ch <- OHLCOpen
+	ch <- OHLCHigh
+	ch <- OHLCLow
+	ch <- OHLCClose
+	ch <- OHLCOpenTimestamp
+	ch <- OHLCCloseTimestamp
+}
+
+// Collect OHLC API call. Symbols which cannot be collected are recorded in
+// the report and skipped.
+func (o *OHLC) Collect(ctx context.Context, client *iex.Client, ch chan<- prometheus.Metric) error {
+	report := ReportFrom(ctx)
+	for _, symbol := range o.Symbols {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		report.Attempt(symbol)
+		var data OHLCData
+		if err := client.GetJSON(fmt.Sprintf("/stock/%s/ohlc", url.PathEscape(symbol)), &data); err != nil {
+			report.Fail(symbol, "stock/"+symbol+"/ohlc", err)
+			continue
+		}
+		o.collect(symbol, &data, ch)
+	}
+	return nil
+}
+
+// collect sends the metrics of the day, the fields sent as null are skipped
+func (o *OHLC) collect(symbol string, data *OHLCData, ch chan<- prometheus.Metric) {
+	send := func(desc *prometheus.Desc, v *float64) {
+		if v != nil {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, *v, symbol, SessionToday)
+		}
+	}
+	sendPrice := func(desc, timestamp *prometheus.Desc, p *OHLCPrice) {
+		if p == nil || p.Price == nil {
+			return
+		}
+		send(desc, p.Price)
+		if p.Time != nil && *p.Time > 0 {
+			t := float64(*p.Time) / float64(time.Second/time.Millisecond)
+			send(timestamp, &t)
+		}
+	}
+	sendPrice(OHLCOpen, OHLCOpenTimestamp, data.Open)
+	send(OHLCHigh, data.High)
+	send(OHLCLow, data.Low)
+	sendPrice(OHLCClose, OHLCCloseTimestamp, data.Close)
+}
+
+// BatchRequests returns the OHLC to fetch from the batch endpoint
+func (o *OHLC) BatchRequests() []BatchRequest {
+	requests := make([]BatchRequest, 0, len(o.Symbols))
+	for _, symbol := range o.Symbols {
+		requests = append(requests, BatchRequest{Symbol: symbol, Type: BatchOHLC})
+	}
+	return requests
+}
+
+// CollectBatch sends the OHLC fetched from the batch endpoint, skipping the
+// missing symbols
+func (o *OHLC) CollectBatch(ctx context.Context, result BatchResult, ch chan<- prometheus.Metric) error {
+	report := ReportFrom(ctx)
+	for _, symbol := range o.Symbols {
+		report.Attempt(symbol)
+		data := result.Get(symbol)
+		if data == nil || data.OHLC == nil {
+			report.Fail(symbol, BatchEndpoint, result.Missing(symbol, "ohlc"))
+			continue
+		}
+		o.collect(symbol, data.OHLC, ch)
+	}
+	return nil
+}
+
+// Name returns the config key of the previous group
+func (p *Previous) Name() string {
+	return "previous"
+}
+
+// Configure decodes and validates the previous group parameters
+func (p *Previous) Configure(params json.RawMessage) error {
+	if err := config.Decode(params, p); err != nil {
+		return err
+	}
+	return config.ValidateSymbols(p.Symbols)
+}
+
+// Describe sends the previous metric descriptors
+func (p *Previous) Describe(ch chan<- *prometheus.Desc) {
+	ch <- OHLCOpen
+	ch <- OHLCHigh
+	ch <- OHLCLow
+	ch <- OHLCClose
+	ch <- OHLCVolume
+}
+
+// Collect PreviousDay API call. Symbols which cannot be collected are recorded
+// in the report and skipped.
+func (p *Previous) Collect(ctx context.Context, client *iex.Client, ch chan<- prometheus.Metric) error {
+	report := ReportFrom(ctx)
+	for _, symbol := range p.Symbols {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		report.Attempt(symbol)
+		data, err := client.PreviousDay(symbol)
+		if err != nil {
+			report.Fail(symbol, "stock/"+symbol+"/previous", err)
+			continue
+		}
+		p.collect(symbol, &data, ch)
+	}
+	return nil
+}
+
+func (p *Previous) collect(symbol string, data *iex.PreviousDay, ch chan<- prometheus.Metric) {
+	for _, m := range []struct {
+		desc  *prometheus.Desc
+		value float64
+	}{
+		{OHLCOpen, data.Open},
+		{OHLCHigh, data.High},
+		{OHLCLow, data.Low},
+		{OHLCClose, data.Close},
+		{OHLCVolume, float64(data.Volume)},
+	} {
+		ch <- prometheus.MustNewConstMetric(m.desc, prometheus.GaugeValue, m.value, symbol, SessionPrevious)
+	}
+}
+
+// BatchRequests returns the previous days to fetch from the batch endpoint
+func (p *Previous) BatchRequests() []BatchRequest {
+	requests := make([]BatchRequest, 0, len(p.Symbols))
+	for _, symbol := range p.Symbols {
+		requests = append(requests, BatchRequest{Symbol: symbol, Type: BatchPrevious})
+	}
+	return requests
+}
+
+// CollectBatch sends the previous days fetched from the batch endpoint,
+// skipping the missing symbols
+func (p *Previous) CollectBatch(ctx context.Context, result BatchResult, ch chan<- prometheus.Metric) error {
+	report := ReportFrom(ctx)
+	for _, symbol := range p.Symbols {
+		report.Attempt(symbol)
+		data := result.Get(symbol)
+		if data == nil || data.Previous == nil {
+			report.Fail(symbol, BatchEndpoint, result.Missing(symbol, "previous day"))
+			continue
+		}
+		p.collect(symbol, data.Previous, ch)
+	}
+	return nil
+}
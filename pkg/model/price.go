@@ -25,6 +25,9 @@ SOFTWARE.
 package model
 
 import (
+	"context"
+	"encoding/json"
+
 	"github.com/prometheus/client_golang/prometheus"
 	// TODO: Replace with github.com/goinvest/iexcloud once https://github.com/goinvest/iexcloud/issues/41 is closed
 	iex "github.com/vglafirov/iexcloud"
@@ -43,34 +46,78 @@ var (
 
 // Price data
 type Price struct {
-	Client  *iex.Client
-	Symbols []string
-	Price   float64
+	Symbols []string `json:"symbols"`
+}
+
+func init() {
+	Register("price", func() Collector { return &Price{} })
+}
+
+// Name returns the config key of the price group
+func (p *Price) Name() string {
+	return "price"
+}
+
+// RealTime marks the price group as real-time
+func (p *Price) RealTime() {}
+
+// Configure decodes and validates the price group parameters
+func (p *Price) Configure(params json.RawMessage) error {
+	if err := config.Decode(params, p); err != nil {
+		return err
+	}
+	return config.ValidateSymbols(p.Symbols)
 }
 
-// API Price API call
-func (p *Price) API(ch chan<- prometheus.Metric) error {
+// Describe sends the price metric descriptors
+func (p *Price) Describe(ch chan<- *prometheus.Desc) {
+	ch <- PriceMetric
+}
+
+// Collect Price API call. Symbols which cannot be collected are recorded in
+// the report and skipped.
+func (p *Price) Collect(ctx context.Context, client *iex.Client, ch chan<- prometheus.Metric) error {
+	report := ReportFrom(ctx)
 	for _, symbol := range p.Symbols {
-		var err error
-		p.Price, err = p.Client.Price(symbol)
-		if err != nil {
+		if err := ctx.Err(); err != nil {
 			return err
-
+		}
+		report.Attempt(symbol)
+		price, err := client.Price(symbol)
+		if err != nil {
+			report.Fail(symbol, "stock/"+symbol+"/price", err)
+			continue
 		}
 		ch <- prometheus.MustNewConstMetric(
-			PriceMetric, prometheus.GaugeValue, float64(p.Price), symbol,
+			PriceMetric, prometheus.GaugeValue, price, symbol,
 		)
 	}
 	return nil
 }
 
-// SetPriceParams Converts map of unknown parameters to symbols
-func SetPriceParams(p interface{}) []string {
-	params := p.(map[string]interface{})
-	s := params["symbols"].([]interface{})
-	var symbols []string
-	for _, symbol := range s {
-		symbols = append(symbols, symbol.(string))
+// BatchRequests returns the prices to fetch from the batch endpoint
+func (p *Price) BatchRequests() []BatchRequest {
+	requests := make([]BatchRequest, 0, len(p.Symbols))
+	for _, symbol := range p.Symbols {
+		requests = append(requests, BatchRequest{Symbol: symbol, Type: BatchPrice})
+	}
+	return requests
+}
+
+// CollectBatch sends the prices fetched from the batch endpoint, skipping the
+// missing symbols
+func (p *Price) CollectBatch(ctx context.Context, result BatchResult, ch chan<- prometheus.Metric) error {
+	report := ReportFrom(ctx)
+	for _, symbol := range p.Symbols {
+		report.Attempt(symbol)
+		data := result.Get(symbol)
+		if data == nil || data.Price == nil {
+			report.Fail(symbol, BatchEndpoint, result.Missing(symbol, "price"))
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			PriceMetric, prometheus.GaugeValue, *data.Price, symbol,
+		)
 	}
-	return symbols
+	return nil
 }
@@ -0,0 +1,202 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	// TODO: Replace with github.com/goinvest/iexcloud once https://github.com/goinvest/iexcloud/issues/41 is closed
+	iex "github.com/vglafirov/iexcloud"
+	"github.com/vglafirov/iexcloud_exporter/pkg/config"
+)
+
+// QuoteData quote endpoint response. The fields IEX Cloud may send as null,
+// e.g. open and close during the trading day, are pointers.
+type QuoteData struct {
+	CalculationPrice      string   `json:"calculationPrice"`
+	Open                  *float64 `json:"open"`
+	Close                 *float64 `json:"close"`
+	High                  *float64 `json:"high"`
+	Low                   *float64 `json:"low"`
+	LatestPrice           *float64 `json:"latestPrice"`
+	LatestSource          string   `json:"latestSource"`
+	LatestUpdate          *int64   `json:"latestUpdate"`
+	LatestVolume          *float64 `json:"latestVolume"`
+	PreviousClose         *float64 `json:"previousClose"`
+	Change                *float64 `json:"change"`
+	ChangePercent         *float64 `json:"changePercent"`
+	ExtendedPrice         *float64 `json:"extendedPrice"`
+	ExtendedChangePercent *float64 `json:"extendedChangePercent"`
+	IEXBidPrice           *float64 `json:"iexBidPrice"`
+	IEXBidSize            *float64 `json:"iexBidSize"`
+	IEXAskPrice           *float64 `json:"iexAskPrice"`
+	IEXAskSize            *float64 `json:"iexAskSize"`
+	AvgTotalVolume        *float64 `json:"avgTotalVolume"`
+}
+
+// quoteGauge is a quote field exported as a gauge
+type quoteGauge struct {
+	desc  *prometheus.Desc
+	value func(q *QuoteData) *float64
+}
+
+func newQuoteDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "quote", name),
+		help,
+		[]string{"symbol"},
+		nil,
+	)
+}
+
+var (
+	// QuoteInfo Prometheus metric definition for the quote sources
+	QuoteInfo = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "quote", "info"),
+		"Sources of the quote: the price the change is calculated from (tops, sip, previousclose or close) and the source of the latest price.",
+		[]string{"symbol", "calculation_price", "latest_source"},
+		nil,
+	)
+
+	quoteGauges = []quoteGauge{
+		{newQuoteDesc("open", "Official open price."), func(q *QuoteData) *float64 { return q.Open }},
+		{newQuoteDesc("close", "Official close price."), func(q *QuoteData) *float64 { return q.Close }},
+		{newQuoteDesc("high", "Market-wide highest price of the day."), func(q *QuoteData) *float64 { return q.High }},
+		{newQuoteDesc("low", "Market-wide lowest price of the day."), func(q *QuoteData) *float64 { return q.Low }},
+		{newQuoteDesc("latest_price", "Latest price, from the source reported by iexcloud_quote_info."), func(q *QuoteData) *float64 { return q.LatestPrice }},
+		{newQuoteDesc("latest_volume", "Total volume of the stock for the day."), func(q *QuoteData) *float64 { return q.LatestVolume }},
+		{newQuoteDesc("previous_close", "Close price of the previous trading day."), func(q *QuoteData) *float64 { return q.PreviousClose }},
+		{newQuoteDesc("change", "Change of the latest price from the previous close."), func(q *QuoteData) *float64 { return q.Change }},
+		{newQuoteDesc("change_ratio", "Change of the latest price from the previous close, as a ratio."), func(q *QuoteData) *float64 { return q.ChangePercent }},
+		{newQuoteDesc("extended_price", "Pre-market or post-market price."), func(q *QuoteData) *float64 { return q.ExtendedPrice }},
+		{newQuoteDesc("extended_change_ratio", "Change of the extended-hours price from the latest price, as a ratio."), func(q *QuoteData) *float64 { return q.ExtendedChangePercent }},
+		{newQuoteDesc("iex_bid_price", "Best bid price on IEX."), func(q *QuoteData) *float64 { return q.IEXBidPrice }},
+		{newQuoteDesc("iex_bid_size", "Number of shares on the bid on IEX."), func(q *QuoteData) *float64 { return q.IEXBidSize }},
+		{newQuoteDesc("iex_ask_price", "Best ask price on IEX."), func(q *QuoteData) *float64 { return q.IEXAskPrice }},
+		{newQuoteDesc("iex_ask_size", "Number of shares on the ask on IEX."), func(q *QuoteData) *float64 { return q.IEXAskSize }},
+		{newQuoteDesc("avg_total_volume", "30 day average volume."), func(q *QuoteData) *float64 { return q.AvgTotalVolume }},
+	}
+)
+
+// Quote data
+type Quote struct {
+	Symbols []string `json:"symbols"`
+}
+
+func init() {
+	Register("quote", func() Collector { return &Quote{} })
+}
+
+// Name returns the config key of the quote group
+func (q *Quote) Name() string {
+	return "quote"
+}
+
+// RealTime marks the quote group as real-time
+func (q *Quote) RealTime() {}
+
+// Configure decodes and validates the quote group parameters
+func (q *Quote) Configure(params json.RawMessage) error {
+	if err := config.Decode(params, q); err != nil {
+		return err
+	}
+	return config.ValidateSymbols(q.Symbols)
+}
+
+// Describe sends the quote metric descriptors
+func (q *Quote) Describe(ch chan<- *prometheus.Desc) {
+	ch <- QuoteInfo
+	for _, g := range quoteGauges {
+		ch <- g.desc
+	}
+}
+
+// Collect Quote API call. Symbols which cannot be collected are recorded in
+// the report and skipped.
+func (q *Quote) Collect(ctx context.Context, client *iex.Client, ch chan<- prometheus.Metric) error {
+	report := ReportFrom(ctx)
+	for _, symbol := range q.Symbols {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		report.Attempt(symbol)
+		var data QuoteData
+		if err := client.GetJSON(fmt.Sprintf("/stock/%s/quote", url.PathEscape(symbol)), &data); err != nil {
+			report.Fail(symbol, "stock/"+symbol+"/quote", err)
+			continue
+		}
+		q.collect(symbol, &data, ch)
+	}
+	return nil
+}
+
+// collect sends the metrics of the quote, stamped with its latest update
+// when IEX Cloud sends one. The fields sent as null are skipped.
+func (q *Quote) collect(symbol string, data *QuoteData, ch chan<- prometheus.Metric) {
+	send := func(m prometheus.Metric) {
+		if data.LatestUpdate != nil && *data.LatestUpdate > 0 {
+			m = prometheus.NewMetricWithTimestamp(time.Unix(0, *data.LatestUpdate*int64(time.Millisecond)), m)
+		}
+		ch <- m
+	}
+	send(prometheus.MustNewConstMetric(
+		QuoteInfo, prometheus.GaugeValue, 1, symbol, data.CalculationPrice, data.LatestSource,
+	))
+	for _, g := range quoteGauges {
+		if v := g.value(data); v != nil {
+			send(prometheus.MustNewConstMetric(g.desc, prometheus.GaugeValue, *v, symbol))
+		}
+	}
+}
+
+// BatchRequests returns the quotes to fetch from the batch endpoint
+func (q *Quote) BatchRequests() []BatchRequest {
+	requests := make([]BatchRequest, 0, len(q.Symbols))
+	for _, symbol := range q.Symbols {
+		requests = append(requests, BatchRequest{Symbol: symbol, Type: BatchQuote})
+	}
+	return requests
+}
+
+// CollectBatch sends the quotes fetched from the batch endpoint, skipping the
+// missing symbols
+func (q *Quote) CollectBatch(ctx context.Context, result BatchResult, ch chan<- prometheus.Metric) error {
+	report := ReportFrom(ctx)
+	for _, symbol := range q.Symbols {
+		report.Attempt(symbol)
+		data := result.Get(symbol)
+		if data == nil || data.Quote == nil {
+			report.Fail(symbol, BatchEndpoint, result.Missing(symbol, "quote"))
+			continue
+		}
+		q.collect(symbol, data.Quote, ch)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/vglafirov/iexcloud_exporter/pkg/transport"
+)
+
+// Failure a symbol which could not be collected
+type Failure struct {
+	Symbol string
+	// Endpoint the symbol was queried from
+	Endpoint string
+	Err      error
+}
+
+// Report records the symbols a refresh attempted and the ones which failed. It
+// is safe for concurrent use, a nil Report discards everything.
+type Report struct {
+	mtx       sync.Mutex
+	attempted map[string]bool
+	failed    map[string]bool
+	failures  []Failure
+}
+
+// NewReport returns an empty report
+func NewReport() *Report {
+	return &Report{
+		attempted: make(map[string]bool),
+		failed:    make(map[string]bool),
+	}
+}
+
+// Attempt records that the symbol was queried
+func (r *Report) Attempt(symbol string) {
+	if r == nil {
+		return
+	}
+	r.mtx.Lock()
+	r.attempted[symbol] = true
+	r.mtx.Unlock()
+}
+
+// Fail records that the symbol could not be collected from the endpoint. The
+// API token is redacted from the error.
+func (r *Report) Fail(symbol, endpoint string, err error) {
+	if r == nil {
+		return
+	}
+	r.mtx.Lock()
+	r.attempted[symbol] = true
+	r.failed[symbol] = true
+	r.failures = append(r.failures, Failure{Symbol: symbol, Endpoint: endpoint, Err: transport.Redact(err)})
+	r.mtx.Unlock()
+}
+
+// Attempted returns the number of symbols queried
+func (r *Report) Attempted() int {
+	if r == nil {
+		return 0
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return len(r.attempted)
+}
+
+// Failed returns the number of symbols which could not be collected
+func (r *Report) Failed() int {
+	if r == nil {
+		return 0
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return len(r.failed)
+}
+
+// Failures returns the failures in the order they were recorded
+func (r *Report) Failures() []Failure {
+	if r == nil {
+		return nil
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]Failure(nil), r.failures...)
+}
+
+// Succeeded returns the sorted symbols which were attempted and never failed
+func (r *Report) Succeeded() []string {
+	if r == nil {
+		return nil
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	var symbols []string
+	for symbol := range r.attempted {
+		if !r.failed[symbol] {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+type reportKey struct{}
+
+// WithReport returns a copy of ctx carrying the report the collectors record
+// their symbols in
+func WithReport(ctx context.Context, r *Report) context.Context {
+	return context.WithValue(ctx, reportKey{}, r)
+}
+
+// ReportFrom returns the report carried by ctx, or nil
+func ReportFrom(ctx context.Context) *Report {
+	r, _ := ctx.Value(reportKey{}).(*Report)
+	return r
+}
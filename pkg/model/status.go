@@ -0,0 +1,135 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	// TODO: Replace with github.com/goinvest/iexcloud once https://github.com/goinvest/iexcloud/issues/41 is closed
+	iex "github.com/vglafirov/iexcloud"
+	"github.com/vglafirov/iexcloud_exporter/pkg/config"
+)
+
+var (
+	// APIStatus Prometheus metric definition for the IEX Cloud system status
+	APIStatus = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "api", "status"),
+		"Whether the IEX Cloud system status is up.",
+		nil,
+		nil,
+	)
+
+	// APIInfo Prometheus metric definition for the IEX Cloud API version
+	APIInfo = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "api", "info"),
+		"Version of the IEX Cloud API.",
+		[]string{"version"},
+		nil,
+	)
+
+	// APIStatusTime Prometheus metric definition for the IEX Cloud status time
+	APIStatusTime = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "api", "status_timestamp_seconds"),
+		"Time reported by the IEX Cloud system status.",
+		nil,
+		nil,
+	)
+)
+
+// Status data
+type Status struct {
+	latency prometheus.Histogram
+}
+
+func init() {
+	Register("status", func() Collector { return NewStatus() })
+}
+
+// NewStatus returns a status group with an empty latency histogram
+func NewStatus() *Status {
+	return &Status{
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: config.Namespace,
+			Subsystem: "api",
+			Name:      "status_request_duration_seconds",
+			Help:      "Round-trip time of the IEX Cloud system status requests.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+	}
+}
+
+// Name returns the config key of the status group
+func (s *Status) Name() string {
+	return "status"
+}
+
+// ReportsFailures marks the status group as reporting its failures, IEX
+// Cloud is reported down while the status cannot be fetched
+func (s *Status) ReportsFailures() {}
+
+// Configure decodes and validates the status group parameters. The group
+// takes none.
+func (s *Status) Configure(params json.RawMessage) error {
+	return config.Decode(params, s)
+}
+
+// Describe sends the status metric descriptors
+func (s *Status) Describe(ch chan<- *prometheus.Desc) {
+	ch <- APIStatus
+	ch <- APIInfo
+	ch <- APIStatusTime
+	ch <- s.latency.Desc()
+}
+
+// Collect Status API call. The round-trip time is observed whether or not the
+// request succeeds, so that slow failures show up in the histogram. The
+// status is down if the request fails.
+func (s *Status) Collect(ctx context.Context, client *iex.Client, ch chan<- prometheus.Metric) error {
+	start := time.Now()
+	status, err := client.Status()
+	s.latency.Observe(time.Since(start).Seconds())
+	ch <- s.latency
+	if err != nil {
+		ch <- prometheus.MustNewConstMetric(APIStatus, prometheus.GaugeValue, 0)
+		return err
+	}
+
+	up := 0.0
+	if strings.EqualFold(status.Status, "up") {
+		up = 1
+	}
+	ch <- prometheus.MustNewConstMetric(APIStatus, prometheus.GaugeValue, up)
+	ch <- prometheus.MustNewConstMetric(APIInfo, prometheus.GaugeValue, 1, status.Version)
+	if t := time.Time(status.Time); !t.IsZero() {
+		ch <- prometheus.MustNewConstMetric(
+			APIStatusTime, prometheus.GaugeValue, float64(t.UnixNano())/1e9,
+		)
+	}
+	return nil
+}
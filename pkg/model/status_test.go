@@ -0,0 +1,103 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package model
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	iex "github.com/vglafirov/iexcloud"
+	"github.com/vglafirov/iexcloud_exporter/pkg/iextest"
+	"github.com/vglafirov/iexcloud_exporter/pkg/transport"
+)
+
+func TestStatus(t *testing.T) {
+	s := iextest.NewServer(iextest.DefaultFixtures().Merge(transport.Fixtures{
+		"/status": `{"status":"down","version":"beta","time":1573430400000}`,
+	}))
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	status := NewStatus()
+	if err := status.Configure([]byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(collectorFunc{status, iex.NewClient("test", server.URL+"/stable/")})
+
+	expected := `
+# HELP iexcloud_api_info Version of the IEX Cloud API.
+# TYPE iexcloud_api_info gauge
+iexcloud_api_info{version="beta"} 1
+# HELP iexcloud_api_status Whether the IEX Cloud system status is up.
+# TYPE iexcloud_api_status gauge
+iexcloud_api_status 0
+# HELP iexcloud_api_status_timestamp_seconds Time reported by the IEX Cloud system status.
+# TYPE iexcloud_api_status_timestamp_seconds gauge
+iexcloud_api_status_timestamp_seconds 1.5734304e+09
+`
+	names := []string{"iexcloud_api_info", "iexcloud_api_status", "iexcloud_api_status_timestamp_seconds"}
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), names...); err != nil {
+		t.Error(err)
+	}
+
+	// Failed requests are observed too, and report the status down
+	s.Inject(iextest.Fault{Path: "/status", Status: http.StatusInternalServerError})
+	metrics, _, err := collect(status, iex.NewClient("test", server.URL+"/stable/"))
+	if err == nil {
+		t.Error("expected an error")
+	}
+	down := false
+	for _, m := range metrics {
+		if m.Desc() == APIStatus {
+			var metric dto.Metric
+			if err := m.Write(&metric); err != nil {
+				t.Fatal(err)
+			}
+			down = metric.GetGauge().GetValue() == 0
+		}
+	}
+	if !down {
+		t.Error("expected the status to be down when the request fails")
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == "iexcloud_api_status_request_duration_seconds" {
+			if n := family.GetMetric()[0].GetHistogram().GetSampleCount(); n != 3 {
+				t.Errorf("expected 3 observed requests, got %d", n)
+			}
+			return
+		}
+	}
+	t.Error("no request duration histogram")
+}
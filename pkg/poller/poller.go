@@ -0,0 +1,646 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package poller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/vglafirov/iexcloud_exporter/pkg/config"
+	"github.com/vglafirov/iexcloud_exporter/pkg/model"
+	"github.com/vglafirov/iexcloud_exporter/pkg/schedule"
+	"github.com/vglafirov/iexcloud_exporter/pkg/transport"
+)
+
+var (
+	// LastUpdated Prometheus metric definition for the snapshot age
+	LastUpdated = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "", "last_updated_timestamp_seconds"),
+		"Unix time of the last successful refresh of the metric group.",
+		[]string{"collector", "group"},
+		nil,
+	)
+
+	// CollectorSuccess Prometheus metric definition for the refresh outcome
+	CollectorSuccess = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "collector", "success"),
+		"Whether the last refresh of the metric group succeeded.",
+		[]string{"collector", "group"},
+		nil,
+	)
+
+	// CollectorDuration Prometheus metric definition for the refresh duration
+	CollectorDuration = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "collector", "duration_seconds"),
+		"Duration of the last refresh of the metric group.",
+		[]string{"collector", "group"},
+		nil,
+	)
+
+	// SymbolsAttempted Prometheus metric definition for the queried symbols
+	SymbolsAttempted = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "collector", "symbols_attempted"),
+		"Number of symbols queried by the last refresh of the metric group.",
+		[]string{"collector", "group"},
+		nil,
+	)
+
+	// SymbolsFailed Prometheus metric definition for the failed symbols
+	SymbolsFailed = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "collector", "symbols_failed"),
+		"Number of symbols which could not be collected by the last refresh of the metric group.",
+		[]string{"collector", "group"},
+		nil,
+	)
+
+	// SymbolLastSuccess Prometheus metric definition for the symbol freshness
+	SymbolLastSuccess = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "symbol", "last_success_timestamp_seconds"),
+		"Unix time of the last successful collection of the symbol.",
+		[]string{"collector", "symbol"},
+		nil,
+	)
+
+	// SymbolErrors Prometheus metric definition for the symbol failures
+	SymbolErrors = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "symbol", "errors_total"),
+		"Number of failed collections of the symbol.",
+		[]string{"collector", "symbol"},
+		nil,
+	)
+)
+
+// Group is a configured metric group refreshed by the poller
+type Group struct {
+	Collector model.Collector
+	// Interval between two refreshes
+	Interval time.Duration
+	// Schedule replaces the interval when set. Groups with neither an
+	// interval nor a schedule are refreshed on every scrape.
+	Schedule *schedule.Schedule
+	// Token API token the group is billed to
+	Token string
+	// Market pauses the refreshes of the group while it is closed when set.
+	// The group is still refreshed once if it has no snapshot yet.
+	Market Market
+	// Filter keeps the metrics whose name it accepts, all of them when unset
+	Filter func(name string) bool
+	// Symbols collected by the group, the per-symbol metrics of the other
+	// symbols are not carried over a reload
+	Symbols []string
+}
+
+// Market tells whether the market is open
+type Market interface {
+	Open(t time.Time) bool
+}
+
+// background reports whether the group is refreshed by the poller rather than
+// on scrape
+func (g Group) background() bool {
+	return g.Interval != 0 || g.Schedule != nil
+}
+
+// nextRefresh returns the time of the refresh following now
+func (g Group) nextRefresh(now time.Time) time.Time {
+	if g.Schedule != nil {
+		return g.Schedule.Next(now)
+	}
+	return now.Add(g.Interval)
+}
+
+// outcome of the last refresh of a group
+type outcome struct {
+	done      bool
+	success   bool
+	duration  time.Duration
+	attempted int
+	failed    int
+}
+
+type group struct {
+	Group
+	index string
+	// rejected descriptors of the metrics left out by the filter
+	rejected map[*prometheus.Desc]bool
+
+	// refresh serialises the refreshes of the group
+	refresh sync.Mutex
+
+	mtx     sync.RWMutex
+	metrics []prometheus.Metric
+	updated time.Time
+	outcome outcome
+	next    time.Time
+	// finished is set once the schedule has no upcoming match
+	finished bool
+	// carried is set until the first refresh of a snapshot carried over
+	// from the previous poller
+	carried bool
+}
+
+// Poller refreshes the metric groups in the background and keeps the results
+// in an in-memory snapshot, so that scrapes do not hit the IEX Cloud API
+type Poller struct {
+	client  model.ClientFunc
+	timeout time.Duration
+	logger  log.Logger
+	groups  []*group
+
+	mtx          sync.RWMutex
+	lastSuccess  map[symbolKey]time.Time
+	symbolErrors map[symbolKey]float64
+}
+
+type symbolKey struct {
+	collector string
+	symbol    string
+}
+
+// New returns a poller for the given metric groups. Background refreshes are
+// cancelled after timeout.
+func New(client model.ClientFunc, groups []Group, timeout time.Duration, logger log.Logger) *Poller {
+	p := &Poller{
+		client:  client,
+		timeout: timeout,
+		logger:  logger,
+
+		lastSuccess:  make(map[symbolKey]time.Time),
+		symbolErrors: make(map[symbolKey]float64),
+	}
+	for i, g := range groups {
+		group := &group{Group: g, index: strconv.Itoa(i)}
+		if g.Filter != nil {
+			group.rejected = model.Rejected(g.Collector, g.Filter)
+		}
+		p.groups = append(p.groups, group)
+	}
+	return p
+}
+
+// Carry takes over the state of prev, the poller of the previous config: the
+// metrics of the symbols still collected by a group of the same collector, and
+// the snapshot, outcome and collector state of the groups collecting the same
+// metrics at the same position. The carried snapshots are served until the groups are
+// refreshed, so that a config reload does not empty the metrics. It must be
+// called before the poller runs.
+func (p *Poller) Carry(prev *Poller) {
+	symbols := make(map[symbolKey]bool)
+	for _, g := range p.groups {
+		for _, symbol := range g.Symbols {
+			symbols[symbolKey{collector: g.Collector.Name(), symbol: strings.ToUpper(symbol)}] = true
+		}
+	}
+	kept := func(key symbolKey) bool {
+		return symbols[symbolKey{collector: key.collector, symbol: strings.ToUpper(key.symbol)}]
+	}
+	prev.mtx.RLock()
+	for key, t := range prev.lastSuccess {
+		if kept(key) {
+			p.lastSuccess[key] = t
+		}
+	}
+	for key, n := range prev.symbolErrors {
+		if kept(key) {
+			p.symbolErrors[key] = n
+		}
+	}
+	prev.mtx.RUnlock()
+
+	for i, g := range p.groups {
+		if i >= len(prev.groups) || prev.groups[i].Collector.Name() != g.Collector.Name() {
+			continue
+		}
+		old := prev.groups[i]
+		if c, ok := g.Collector.(model.StatefulCollector); ok {
+			c.Carry(old.Collector)
+		}
+		old.mtx.RLock()
+		for _, m := range old.metrics {
+			if !g.rejected[m.Desc()] {
+				g.metrics = append(g.metrics, m)
+			}
+		}
+		g.updated, g.outcome = old.updated, old.outcome
+		old.mtx.RUnlock()
+		g.carried = !g.updated.IsZero()
+	}
+}
+
+// Run refreshes the metric groups on their interval or schedule until ctx is
+// cancelled. All the groups are refreshed once right away.
+func (p *Poller) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-timer.C:
+			if due := p.due(now); len(due) > 0 {
+				go func() {
+					ctx, cancel := context.WithTimeout(ctx, p.timeout)
+					defer cancel()
+					p.refreshAll(ctx, due)
+				}()
+			}
+			if next, ok := p.next(); ok {
+				timer.Reset(time.Until(next))
+			}
+		}
+	}
+}
+
+// due returns the background groups whose refresh is due and schedules their
+// next refresh. Refreshes of the groups paused at now are skipped.
+func (p *Poller) due(now time.Time) []*group {
+	var due []*group
+	for _, g := range p.groups {
+		if !g.background() || g.finished || g.next.After(now) {
+			continue
+		}
+		g.next = g.nextRefresh(now)
+		g.finished = g.next.IsZero()
+		if g.paused(now) {
+			level.Debug(p.logger).Log("msg", "market closed, refresh skipped", "collector", g.Collector.Name(), "group", g.index)
+			continue
+		}
+		due = append(due, g)
+	}
+	return due
+}
+
+// paused reports whether the refreshes of the group are paused at now. A
+// carried snapshot does not pause the group, it may have been collected with
+// another config.
+func (g *group) paused(now time.Time) bool {
+	if g.Market == nil || g.Market.Open(now) {
+		return false
+	}
+	g.mtx.RLock()
+	defer g.mtx.RUnlock()
+	return !g.updated.IsZero() && !g.carried
+}
+
+// next returns the time of the earliest scheduled refresh
+func (p *Poller) next() (time.Time, bool) {
+	var next time.Time
+	for _, g := range p.groups {
+		if !g.background() || g.finished {
+			continue
+		}
+		if next.IsZero() || g.next.Before(next) {
+			next = g.next
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// refreshAll refreshes the given groups concurrently and returns the metrics
+// collected for each of them, even if the refresh failed. The requests of the
+// groups billed to the same token which can be served from the batch endpoint
+// are merged.
+func (p *Poller) refreshAll(ctx context.Context, groups []*group) map[*group][]prometheus.Metric {
+	var (
+		wg      sync.WaitGroup
+		mtx     sync.Mutex
+		results = make(map[*group][]prometheus.Metric, len(groups))
+		batched = make(map[string][]*group)
+	)
+	if len(groups) == 0 {
+		return results
+	}
+	start := time.Now()
+	refresh := func(g *group, collect func(ctx context.Context, ch chan<- prometheus.Metric) error) {
+		defer wg.Done()
+		metrics := p.refresh(ctx, g, start, collect)
+		mtx.Lock()
+		results[g] = metrics
+		mtx.Unlock()
+	}
+
+	for _, g := range groups {
+		if _, ok := g.Collector.(model.BatchCollector); ok {
+			batched[g.Token] = append(batched[g.Token], g)
+			continue
+		}
+		c, client := g.Collector, p.client(ctx, g.Token)
+		wg.Add(1)
+		go refresh(g, func(ctx context.Context, ch chan<- prometheus.Metric) error {
+			return c.Collect(ctx, client, ch)
+		})
+	}
+
+	for token, groups := range batched {
+		var requests []model.BatchRequest
+		for _, g := range groups {
+			requests = append(requests, g.Collector.(model.BatchCollector).BatchRequests()...)
+		}
+		level.Debug(p.logger).Log("msg", "fetching batch", "groups", len(groups), "requests", len(requests))
+		result, err := model.FetchBatch(ctx, p.client(ctx, token), requests)
+		if err != nil {
+			p.logError(err, "msg", "cannot fetch batch")
+		}
+		for _, g := range groups {
+			c := g.Collector.(model.BatchCollector)
+			wg.Add(1)
+			go refresh(g, func(ctx context.Context, ch chan<- prometheus.Metric) error {
+				return c.CollectBatch(ctx, result, ch)
+			})
+		}
+	}
+	wg.Wait()
+	return results
+}
+
+// refresh collects the metrics of the group and replaces its snapshot. The
+// previous snapshot is kept if the collection fails or if every symbol fails,
+// unless the collector reports its failures, and the previous metrics of the
+// symbols which failed are kept otherwise.
+// The collected metrics are returned either way and the outcome of the
+// refresh, started at start, is recorded.
+func (p *Poller) refresh(ctx context.Context, g *group, start time.Time, collect func(ctx context.Context, ch chan<- prometheus.Metric) error) []prometheus.Metric {
+	g.refresh.Lock()
+	defer g.refresh.Unlock()
+
+	name := g.Collector.Name()
+	level.Debug(p.logger).Log("msg", "refreshing metrics", "collector", name, "group", g.index)
+
+	ch := make(chan prometheus.Metric)
+	done := make(chan []prometheus.Metric)
+	go func() {
+		var metrics []prometheus.Metric
+		for m := range ch {
+			if !g.rejected[m.Desc()] {
+				metrics = append(metrics, m)
+			}
+		}
+		done <- metrics
+	}()
+
+	report := model.NewReport()
+	err := collect(model.WithReport(ctx, report), ch)
+	close(ch)
+	metrics := <-done
+
+	now := time.Now()
+	p.recordSymbols(name, report, now)
+
+	attempted, failed := report.Attempted(), report.Failed()
+	if err == nil && attempted > 0 && failed == attempted {
+		// The level of the log depends on the cause of the failures
+		err = fmt.Errorf("every symbol failed: %w", report.Failures()[0].Err)
+	}
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.carried = false
+	g.outcome = outcome{
+		done:      true,
+		success:   err == nil,
+		duration:  now.Sub(start),
+		attempted: attempted,
+		failed:    failed,
+	}
+	if err != nil {
+		p.logError(err, "msg", "cannot collect metrics", "collector", name, "group", g.index, "collected", len(metrics))
+		// The last update time is still the one of the last success
+		if _, ok := g.Collector.(model.FailureCollector); ok {
+			g.metrics = metrics
+		}
+		return metrics
+	}
+	if failed > 0 {
+		metrics = keepFailed(metrics, g.metrics, report)
+	}
+	g.metrics = metrics
+	g.updated = now
+	return metrics
+}
+
+// keepFailed adds to the collected metrics the previous metrics of the
+// symbols which failed, e.g. the symbols of a failed batch request, which
+// were not collected again
+func keepFailed(metrics, previous []prometheus.Metric, report *model.Report) []prometheus.Metric {
+	failed := make(map[string]bool)
+	for _, f := range report.Failures() {
+		failed[strings.ToUpper(f.Symbol)] = true
+	}
+	collected := make(map[string]bool, len(metrics))
+	for _, m := range metrics {
+		if key, _, ok := symbolSeries(m); ok {
+			collected[key] = true
+		}
+	}
+	for _, m := range previous {
+		if key, symbol, ok := symbolSeries(m); ok && failed[strings.ToUpper(symbol)] && !collected[key] {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// symbolSeries returns the series of a per-symbol metric and its symbol
+func symbolSeries(m prometheus.Metric) (string, string, bool) {
+	var metric dto.Metric
+	if err := m.Write(&metric); err != nil {
+		return "", "", false
+	}
+	key, symbol := m.Desc().String(), ""
+	for _, l := range metric.GetLabel() {
+		key += "\xff" + l.GetName() + "=" + l.GetValue()
+		if l.GetName() == "symbol" {
+			symbol = l.GetValue()
+		}
+	}
+	return key, symbol, symbol != ""
+}
+
+// recordSymbols logs the symbols which failed and updates the per-symbol
+// metrics
+func (p *Poller) recordSymbols(collector string, report *model.Report, now time.Time) {
+	failures := report.Failures()
+	for _, f := range failures {
+		p.logError(f.Err, "msg", "cannot collect symbol", "collector", collector, "symbol", f.Symbol, "endpoint", f.Endpoint)
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for _, f := range failures {
+		p.symbolErrors[symbolKey{collector: collector, symbol: f.Symbol}]++
+	}
+	for _, symbol := range report.Succeeded() {
+		p.lastSuccess[symbolKey{collector: collector, symbol: symbol}] = now
+	}
+}
+
+// logError logs a failed refresh. Refreshes skipped because the message
+// budget is spent are expected and only logged as a warning, refreshes skipped
+// while the circuit breaker is open were already reported by the breaker. The
+// API token is redacted from the error.
+func (p *Poller) logError(err error, keyvals ...interface{}) {
+	keyvals = append(keyvals, "err", transport.Redact(err))
+	switch {
+	case errors.Is(err, transport.ErrCircuitOpen):
+		level.Debug(p.logger).Log(keyvals...)
+	case errors.Is(err, transport.ErrBudgetExhausted):
+		level.Warn(p.logger).Log(append(keyvals, "snapshot", "kept")...)
+	default:
+		level.Error(p.logger).Log(keyvals...)
+	}
+}
+
+// Describe sends the descriptors of the poller metrics. It implements
+// prometheus.Collector.
+func (p *Poller) Describe(ch chan<- *prometheus.Desc) {
+	ch <- LastUpdated
+	ch <- CollectorSuccess
+	ch <- CollectorDuration
+	ch <- SymbolsAttempted
+	ch <- SymbolsFailed
+	ch <- SymbolLastSuccess
+	ch <- SymbolErrors
+}
+
+// Up reports whether the last refresh of every group succeeded. The groups
+// which were not refreshed yet are left out, the poller is down until one of
+// them is.
+func (p *Poller) Up() bool {
+	up := false
+	for _, g := range p.groups {
+		g.mtx.RLock()
+		o := g.outcome
+		g.mtx.RUnlock()
+		if !o.done {
+			continue
+		}
+		if !o.success {
+			return false
+		}
+		up = true
+	}
+	return up
+}
+
+// Collect sends the snapshot of all the groups. It implements
+// prometheus.Collector.
+func (p *Poller) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	p.CollectContext(ctx, ch)
+}
+
+// CollectContext refreshes the groups which are polled on every scrape within
+// the deadline of ctx and sends the snapshot of all the groups. Whatever was
+// collected before the deadline is sent for the groups refreshed on scrape.
+// The snapshots of the paused groups are sent without sample timestamps.
+func (p *Poller) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	var onScrape []*group
+	now := time.Now()
+	for _, g := range p.groups {
+		if !g.background() && !g.paused(now) {
+			onScrape = append(onScrape, g)
+		}
+	}
+	fresh := p.refreshAll(ctx, onScrape)
+
+	for _, g := range p.groups {
+		paused := g.paused(now)
+		g.mtx.RLock()
+		metrics, updated, outcome := g.metrics, g.updated, g.outcome
+		g.mtx.RUnlock()
+
+		if m, ok := fresh[g]; ok {
+			metrics = m
+		}
+		for _, m := range metrics {
+			// The samples of the last quote or bar would be stale, or
+			// even rejected, hours after their timestamp
+			if paused {
+				m = untimed{m}
+			}
+			ch <- m
+		}
+		if outcome.done {
+			p.collectOutcome(g, outcome, ch)
+		}
+		if updated.IsZero() {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			LastUpdated, prometheus.GaugeValue, float64(updated.UnixNano())/1e9, g.Collector.Name(), g.index,
+		)
+	}
+
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	for key, n := range p.symbolErrors {
+		ch <- prometheus.MustNewConstMetric(
+			SymbolErrors, prometheus.CounterValue, n, key.collector, key.symbol,
+		)
+	}
+	for key, t := range p.lastSuccess {
+		ch <- prometheus.MustNewConstMetric(
+			SymbolLastSuccess, prometheus.GaugeValue, float64(t.UnixNano())/1e9, key.collector, key.symbol,
+		)
+	}
+}
+
+// untimed is a metric served without the timestamp of its sample
+type untimed struct {
+	prometheus.Metric
+}
+
+// Write implements prometheus.Metric.
+func (m untimed) Write(out *dto.Metric) error {
+	if err := m.Metric.Write(out); err != nil {
+		return err
+	}
+	out.TimestampMs = nil
+	return nil
+}
+
+// collectOutcome sends the metrics describing the last refresh of the group
+func (p *Poller) collectOutcome(g *group, o outcome, ch chan<- prometheus.Metric) {
+	name := g.Collector.Name()
+	success := 0.0
+	if o.success {
+		success = 1
+	}
+	ch <- prometheus.MustNewConstMetric(CollectorSuccess, prometheus.GaugeValue, success, name, g.index)
+	ch <- prometheus.MustNewConstMetric(CollectorDuration, prometheus.GaugeValue, o.duration.Seconds(), name, g.index)
+	ch <- prometheus.MustNewConstMetric(SymbolsAttempted, prometheus.GaugeValue, float64(o.attempted), name, g.index)
+	ch <- prometheus.MustNewConstMetric(SymbolsFailed, prometheus.GaugeValue, float64(o.failed), name, g.index)
+}
@@ -0,0 +1,442 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package poller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	iex "github.com/vglafirov/iexcloud"
+	"github.com/vglafirov/iexcloud_exporter/pkg/model"
+	"github.com/vglafirov/iexcloud_exporter/pkg/transport"
+)
+
+var testMetric = prometheus.NewDesc("test_value", "Test value", nil, nil)
+
+type testCollector struct {
+	value float64
+	// timestamp of the samples when set
+	timestamp time.Time
+	err       error
+	calls     int
+}
+
+func (c *testCollector) Name() string                           { return "test" }
+func (c *testCollector) Configure(params json.RawMessage) error { return nil }
+func (c *testCollector) Describe(ch chan<- *prometheus.Desc)    { ch <- testMetric }
+
+func (c *testCollector) Collect(ctx context.Context, client *iex.Client, ch chan<- prometheus.Metric) error {
+	c.calls++
+	report := model.ReportFrom(ctx)
+	report.Attempt("AAPL")
+	if c.err != nil {
+		report.Fail("AAPL", "stock/AAPL/price", c.err)
+		return c.err
+	}
+	m := prometheus.MustNewConstMetric(testMetric, prometheus.GaugeValue, c.value)
+	if !c.timestamp.IsZero() {
+		m = prometheus.NewMetricWithTimestamp(c.timestamp, m)
+	}
+	ch <- m
+	return nil
+}
+
+func newClient(ctx context.Context, token string) *iex.Client {
+	return nil
+}
+
+func collect(p *Poller) []prometheus.Metric {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		p.Collect(ch)
+		close(ch)
+	}()
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func TestPollerServesSnapshot(t *testing.T) {
+	c := &testCollector{value: 1}
+	p := New(newClient, []Group{{Collector: c, Interval: 1}}, time.Second, log.NewNopLogger())
+
+	if metrics := collect(p); len(metrics) != 0 {
+		t.Fatalf("expected no metrics before the first refresh, got %d", len(metrics))
+	}
+
+	p.refreshAll(context.Background(), p.groups)
+	c.value, c.err = 2, errors.New("failed")
+	p.refreshAll(context.Background(), p.groups)
+
+	metrics := collect(p)
+	expected := []*prometheus.Desc{testMetric, CollectorSuccess, CollectorDuration, SymbolsAttempted, SymbolsFailed, LastUpdated}
+	if len(metrics) < len(expected) {
+		t.Fatalf("expected at least %d metrics, got %d", len(expected), len(metrics))
+	}
+	for i, desc := range expected {
+		if metrics[i].Desc() != desc {
+			t.Fatalf("unexpected metric %s", metrics[i].Desc())
+		}
+	}
+	if c.calls != 2 {
+		t.Fatalf("expected scrapes to be served from the snapshot, got %d calls", c.calls)
+	}
+}
+
+func gauge(t *testing.T, m prometheus.Metric) float64 {
+	var pb dto.Metric
+	if err := m.Write(&pb); err != nil {
+		t.Fatal(err)
+	}
+	return pb.GetGauge().GetValue()
+}
+
+func TestPollerOutcome(t *testing.T) {
+	c := &testCollector{value: 1}
+	p := New(newClient, []Group{{Collector: c, Interval: 1}}, time.Second, log.NewNopLogger())
+
+	if p.Up() {
+		t.Fatal("expected down before the first refresh")
+	}
+	p.refreshAll(context.Background(), p.groups)
+	if !p.Up() {
+		t.Fatal("expected up after a successful refresh")
+	}
+
+	c.err = errors.New("failed")
+	p.refreshAll(context.Background(), p.groups)
+	if p.Up() {
+		t.Fatal("expected down after a failed refresh")
+	}
+
+	values := make(map[*prometheus.Desc]float64)
+	for _, m := range collect(p) {
+		values[m.Desc()] = gauge(t, m)
+	}
+	if values[CollectorSuccess] != 0 {
+		t.Fatalf("expected the failed refresh to be reported, got %g", values[CollectorSuccess])
+	}
+	if values[SymbolsAttempted] != 1 || values[SymbolsFailed] != 1 {
+		t.Fatalf("expected 1 symbol attempted and failed, got %g and %g", values[SymbolsAttempted], values[SymbolsFailed])
+	}
+}
+
+func TestPollerUpEveryGroup(t *testing.T) {
+	stats, price := &testCollector{err: errors.New("failed")}, &testCollector{value: 1}
+	p := New(newClient, []Group{
+		{Collector: stats, Interval: 24 * time.Hour},
+		{Collector: price, Interval: time.Minute},
+		{Collector: &testCollector{value: 1}},
+	}, time.Second, log.NewNopLogger())
+
+	p.refreshAll(context.Background(), p.groups[:1])
+	p.refreshAll(context.Background(), p.groups[1:2])
+	if p.Up() {
+		t.Fatal("expected the failed daily group to be reported after the price refresh")
+	}
+	collect(p)
+	if p.Up() {
+		t.Fatal("expected the failed daily group to be reported after a scrape")
+	}
+
+	stats.err = nil
+	p.refreshAll(context.Background(), p.groups[:1])
+	if !p.Up() {
+		t.Fatal("expected up once every group succeeded")
+	}
+
+	// A stale successful group does not hide the failures of the others
+	price.err = errors.New("failed")
+	p.refreshAll(context.Background(), p.groups[1:2])
+	if p.Up() {
+		t.Fatal("expected down after a failed price refresh")
+	}
+}
+
+// statusCollector reports its failures like the status group
+type statusCollector struct {
+	testCollector
+}
+
+func (c *statusCollector) ReportsFailures() {}
+
+func (c *statusCollector) Collect(ctx context.Context, client *iex.Client, ch chan<- prometheus.Metric) error {
+	ch <- prometheus.MustNewConstMetric(testMetric, prometheus.GaugeValue, c.value)
+	return c.err
+}
+
+func TestPollerReportsFailures(t *testing.T) {
+	c := &statusCollector{testCollector{value: 1}}
+	p := New(newClient, []Group{{Collector: c, Interval: time.Hour}}, time.Second, log.NewNopLogger())
+	p.refreshAll(context.Background(), p.groups)
+	c.value, c.err = 0, errors.New("unreachable")
+	p.refreshAll(context.Background(), p.groups)
+
+	metrics := collect(p)
+	if len(metrics) == 0 || metrics[0].Desc() != testMetric || gauge(t, metrics[0]) != 0 {
+		t.Fatal("expected the metrics of the failed refresh to replace the snapshot")
+	}
+	if p.Up() {
+		t.Fatal("expected the refresh to fail")
+	}
+}
+
+type symbolsCollector struct {
+	testCollector
+	failing string
+}
+
+func (c *symbolsCollector) Collect(ctx context.Context, client *iex.Client, ch chan<- prometheus.Metric) error {
+	report := model.ReportFrom(ctx)
+	for _, symbol := range []string{"AAPL", "WORK", "MSFT"} {
+		report.Attempt(symbol)
+		if symbol == c.failing {
+			report.Fail(symbol, "stock/"+symbol+"/price", errors.New("unknown symbol"))
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(testMetric, prometheus.GaugeValue, 1)
+	}
+	return nil
+}
+
+func TestPollerSymbolFailure(t *testing.T) {
+	c := &symbolsCollector{failing: "WORK"}
+	p := New(newClient, []Group{{Collector: c, Interval: 1}}, time.Second, log.NewNopLogger())
+	p.refreshAll(context.Background(), p.groups)
+
+	var values, lastSuccess int
+	success := -1.0
+	for _, m := range collect(p) {
+		switch m.Desc() {
+		case testMetric:
+			values++
+		case CollectorSuccess:
+			success = gauge(t, m)
+		case SymbolLastSuccess:
+			lastSuccess++
+		}
+	}
+	if values != 2 {
+		t.Fatalf("expected the symbols after the failing one to be collected, got %d values", values)
+	}
+	if success != 1 {
+		t.Fatalf("expected the refresh to succeed, got %g", success)
+	}
+	if lastSuccess != 2 {
+		t.Fatalf("expected the last success of 2 symbols, got %d", lastSuccess)
+	}
+	if n := p.symbolErrors[symbolKey{collector: "test", symbol: "WORK"}]; n != 1 {
+		t.Fatalf("expected 1 error for WORK, got %g", n)
+	}
+}
+
+// budgetCollector fails every symbol like the batch groups once the message
+// budget is spent
+type budgetCollector struct {
+	testCollector
+}
+
+func (c *budgetCollector) Collect(ctx context.Context, client *iex.Client, ch chan<- prometheus.Metric) error {
+	report := model.ReportFrom(ctx)
+	for _, symbol := range []string{"AAPL", "MSFT"} {
+		report.Attempt(symbol)
+		report.Fail(symbol, model.BatchEndpoint, fmt.Errorf("no price returned for %s: %w", symbol, transport.ErrBudgetExhausted))
+	}
+	return nil
+}
+
+func TestPollerBudgetExhausted(t *testing.T) {
+	var buf bytes.Buffer
+	p := New(newClient, []Group{{Collector: &budgetCollector{}, Interval: 1}}, time.Second, level.NewFilter(log.NewLogfmtLogger(&buf), level.AllowAll()))
+	p.refreshAll(context.Background(), p.groups)
+
+	if p.Up() {
+		t.Fatal("expected the refresh to fail")
+	}
+	if strings.Contains(buf.String(), "level=error") || !strings.Contains(buf.String(), "level=warn") {
+		t.Fatalf("expected the spent budget to be logged as warnings only, got:\n%s", buf.String())
+	}
+}
+
+var symbolMetric = prometheus.NewDesc("test_symbol_value", "Test value of the symbol", []string{"symbol"}, nil)
+
+// chunkCollector fails the symbols of a failed batch chunk
+type chunkCollector struct {
+	testCollector
+	failing map[string]bool
+}
+
+func (c *chunkCollector) Collect(ctx context.Context, client *iex.Client, ch chan<- prometheus.Metric) error {
+	report := model.ReportFrom(ctx)
+	for _, symbol := range []string{"AAPL", "MSFT"} {
+		report.Attempt(symbol)
+		if c.failing[symbol] {
+			report.Fail(symbol, model.BatchEndpoint, errors.New("batch request failed"))
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(symbolMetric, prometheus.GaugeValue, c.value, symbol)
+	}
+	return nil
+}
+
+func TestPollerKeepsFailedSymbols(t *testing.T) {
+	c := &chunkCollector{testCollector: testCollector{value: 1}}
+	p := New(newClient, []Group{{Collector: c, Interval: time.Hour}}, time.Second, log.NewNopLogger())
+	p.refreshAll(context.Background(), p.groups)
+	c.value, c.failing = 2, map[string]bool{"MSFT": true}
+	p.refreshAll(context.Background(), p.groups)
+
+	values := make(map[string]float64)
+	for _, m := range collect(p) {
+		if m.Desc() != symbolMetric {
+			continue
+		}
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		values[metric.GetLabel()[0].GetValue()] = metric.GetGauge().GetValue()
+	}
+	if len(values) != 2 || values["AAPL"] != 2 || values["MSFT"] != 1 {
+		t.Fatalf("expected the new AAPL value and the previous MSFT one, got %v", values)
+	}
+}
+
+func TestPollerRefreshesOnScrape(t *testing.T) {
+	c := &testCollector{value: 1}
+	p := New(newClient, []Group{{Collector: c}}, time.Second, log.NewNopLogger())
+
+	collect(p)
+	collect(p)
+	if c.calls != 2 {
+		t.Fatalf("expected a refresh per scrape, got %d calls", c.calls)
+	}
+}
+
+type slowCollector struct {
+	testCollector
+}
+
+func (c *slowCollector) Collect(ctx context.Context, client *iex.Client, ch chan<- prometheus.Metric) error {
+	ch <- prometheus.MustNewConstMetric(testMetric, prometheus.GaugeValue, 1)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestPollerScrapeTimeout(t *testing.T) {
+	p := New(newClient, []Group{{Collector: &slowCollector{}}}, time.Second, log.NewNopLogger())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ch := make(chan prometheus.Metric, 10)
+	p.CollectContext(ctx, ch)
+	close(ch)
+
+	var collected int
+	for m := range ch {
+		if m.Desc() == testMetric {
+			collected++
+		}
+	}
+	if collected != 1 {
+		t.Fatalf("expected the metric collected before the deadline, got %d metrics", collected)
+	}
+}
+
+type closedMarket struct{}
+
+func (closedMarket) Open(t time.Time) bool { return false }
+
+func TestPollerMarketClosed(t *testing.T) {
+	c := &testCollector{value: 1, timestamp: time.Now().Add(-time.Hour)}
+	p := New(newClient, []Group{{Collector: c, Interval: time.Minute, Market: closedMarket{}}}, time.Second, log.NewNopLogger())
+
+	now := time.Now()
+	if due := p.due(now); len(due) != 1 {
+		t.Fatalf("expected a group without snapshot to be refreshed, got %d due groups", len(due))
+	}
+	p.refreshAll(context.Background(), p.groups)
+
+	if due := p.due(now.Add(time.Minute)); len(due) != 0 {
+		t.Fatalf("expected the refresh to be skipped while the market is closed, got %d due groups", len(due))
+	}
+	metrics := collect(p)
+	if len(metrics) == 0 || metrics[0].Desc() != testMetric {
+		t.Fatal("expected the snapshot to be served while the market is closed")
+	}
+	if c.calls != 1 {
+		t.Fatalf("expected a single refresh, got %d", c.calls)
+	}
+	var metric dto.Metric
+	if err := metrics[0].Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	if metric.TimestampMs != nil || metric.GetGauge().GetValue() != 1 {
+		t.Fatalf("expected the snapshot to be served without timestamp while the market is closed, got %v", metric.String())
+	}
+}
+
+func TestPollerCarry(t *testing.T) {
+	c := &symbolsCollector{failing: "WORK"}
+	prev := New(newClient, []Group{{Collector: c, Interval: time.Hour}}, time.Second, log.NewNopLogger())
+	prev.refreshAll(context.Background(), prev.groups)
+
+	// MSFT is removed from the config on reload
+	p := New(newClient, []Group{{
+		Collector: &symbolsCollector{},
+		Interval:  time.Hour,
+		Market:    closedMarket{},
+		Symbols:   []string{"aapl", "work"},
+	}}, time.Second, log.NewNopLogger())
+	p.Carry(prev)
+
+	if metrics := collect(p); len(metrics) == 0 || metrics[0].Desc() != testMetric {
+		t.Fatal("expected the previous snapshot to be served until the first refresh")
+	}
+	if !p.Up() {
+		t.Fatal("expected the outcome of the previous refresh to be kept")
+	}
+	if n := p.symbolErrors[symbolKey{collector: "test", symbol: "WORK"}]; n != 1 || len(p.symbolErrors) != 1 {
+		t.Fatalf("expected the errors of WORK to be kept, got %v", p.symbolErrors)
+	}
+	if _, ok := p.lastSuccess[symbolKey{collector: "test", symbol: "AAPL"}]; !ok || len(p.lastSuccess) != 1 {
+		t.Fatalf("expected the last success of AAPL only to be kept, got %v", p.lastSuccess)
+	}
+	if due := p.due(time.Now()); len(due) != 1 {
+		t.Fatal("expected a carried snapshot to be refreshed while the market is closed")
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("timezone database is not available")
+	}
+	// Friday
+	now := time.Date(2019, 11, 15, 16, 45, 0, 0, ny)
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2019, 11, 15, 17, 0, 0, 0, ny)},
+		{"30 16 * * 1-5", time.Date(2019, 11, 18, 16, 30, 0, 0, ny)},
+		{"0 17 * * mon-fri", time.Time{}},
+		{"@daily", time.Date(2019, 11, 16, 0, 0, 0, 0, ny)},
+		{"0 9 1 * 0", time.Date(2019, 11, 17, 9, 0, 0, 0, ny)},
+		{"0 0 1 1 *", time.Date(2020, 1, 1, 0, 0, 0, 0, ny)},
+		{"0 12 * * 7", time.Date(2019, 11, 17, 12, 0, 0, 0, ny)},
+	}
+
+	for _, test := range tests {
+		s, err := Parse(test.spec, ny)
+		if test.want.IsZero() {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.spec, err)
+			continue
+		}
+		if got := s.Next(now); !got.Equal(test.want) {
+			t.Errorf("%q: expected %s, got %s", test.spec, test.want, got)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "5-1 * * * *", "*/0 * * * *", "* * 0 * *"} {
+		if _, err := Parse(spec, time.UTC); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vglafirov/iexcloud_exporter/pkg/config"
+)
+
+// ErrCircuitOpen is returned instead of calling IEX Cloud while the circuit
+// breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Circuit breaker states
+const (
+	closed = iota
+	open
+	halfOpen
+)
+
+// Outcomes of a request for the circuit breaker
+const (
+	succeeded = iota
+	failed
+	// neutral requests never reached IEX Cloud
+	neutral
+)
+
+var breakerStateNames = map[int]string{
+	closed:   "closed",
+	open:     "open",
+	halfOpen: "half-open",
+}
+
+var breakerState = prometheus.NewDesc(
+	prometheus.BuildFQName(config.Namespace, "circuit_breaker", "state"),
+	"State of the IEX Cloud circuit breaker: 0 closed, 1 open, 2 half-open.",
+	nil, nil,
+)
+
+// Breaker stops calling IEX Cloud after a number of consecutive failures. Once
+// the cooldown has passed a single probe request is let through, which closes
+// the circuit again if it succeeds. A probe which never reached IEX Cloud
+// leaves the circuit half-open for the next one.
+type Breaker struct {
+	next      http.RoundTripper
+	threshold int
+	cooldown  time.Duration
+	logger    log.Logger
+	now       func() time.Time
+
+	mtx      sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	// probing is set while the probe of the half-open circuit is in flight
+	probing bool
+
+	rejected prometheus.Counter
+}
+
+// NewBreaker returns a circuit breaker opening after threshold consecutive
+// failures. A zero threshold disables the breaker.
+func NewBreaker(next http.RoundTripper, threshold int, cooldown time.Duration, logger log.Logger) *Breaker {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Breaker{
+		next:      next,
+		threshold: threshold,
+		cooldown:  cooldown,
+		logger:    logger,
+		now:       time.Now,
+		rejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: config.Namespace,
+			Subsystem: "circuit_breaker",
+			Name:      "rejected_requests_total",
+			Help:      "Number of IEX Cloud requests rejected while the circuit breaker was open.",
+		}),
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (b *Breaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	if b.threshold <= 0 {
+		return b.next.RoundTrip(req)
+	}
+	probe, err := b.allow()
+	if err != nil {
+		b.rejected.Inc()
+		return nil, err
+	}
+	resp, err := b.next.RoundTrip(req)
+	b.record(err, probe)
+	return resp, err
+}
+
+// allow tells whether a request may call IEX Cloud, and whether it is the
+// probe of the half-open circuit
+func (b *Breaker) allow() (bool, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	switch b.state {
+	case open:
+		if wait := b.openedAt.Add(b.cooldown).Sub(b.now()); wait > 0 {
+			return false, fmt.Errorf("%w, retrying in %s", ErrCircuitOpen, wait.Round(time.Second))
+		}
+		b.setState(halfOpen)
+		b.probing = true
+		return true, nil
+	case halfOpen:
+		if b.probing {
+			return false, ErrCircuitOpen
+		}
+		b.probing = true
+		return true, nil
+	}
+	return false, nil
+}
+
+func (b *Breaker) record(err error, probe bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+	switch outcome(err) {
+	case neutral:
+		return
+	case succeeded:
+		b.failures = 0
+		if b.state != closed {
+			b.setState(closed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == halfOpen || b.failures >= b.threshold {
+		if b.state != open {
+			level.Warn(b.logger).Log("msg", "IEX Cloud keeps failing, pausing requests", "failures", b.failures, "cooldown", b.cooldown, "err", Redact(err))
+		}
+		b.openedAt = b.now()
+		b.setState(open)
+	}
+}
+
+func (b *Breaker) setState(state int) {
+	if state == closed && b.state != closed {
+		level.Info(b.logger).Log("msg", "IEX Cloud is reachable again, resuming requests")
+	}
+	level.Debug(b.logger).Log("msg", "circuit breaker state changed", "from", breakerStateNames[b.state], "to", breakerStateNames[state])
+	b.state = state
+}
+
+// outcome tells whether err counts towards opening the circuit. Client errors
+// such as an unknown symbol say nothing about the health of IEX Cloud, they
+// are answers. Requests rejected by the limiter, timed out while waiting for
+// it or cancelled never reached IEX Cloud.
+func outcome(err error) int {
+	var (
+		apiErr  *APIError
+		waitErr *WaitError
+	)
+	switch {
+	case err == nil:
+		return succeeded
+	case errors.Is(err, ErrBudgetExhausted), errors.Is(err, context.Canceled), errors.As(err, &waitErr):
+		return neutral
+	case errors.As(err, &apiErr) && !apiErr.Temporary():
+		return succeeded
+	}
+	return failed
+}
+
+// Describe implements prometheus.Collector.
+func (b *Breaker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- breakerState
+	b.rejected.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (b *Breaker) Collect(ch chan<- prometheus.Metric) {
+	b.mtx.Lock()
+	state := b.state
+	b.mtx.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(breakerState, prometheus.GaugeValue, float64(state))
+	b.rejected.Collect(ch)
+}
@@ -0,0 +1,148 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestBreaker(t *testing.T) {
+	healthy := false
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if !healthy {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	now := time.Date(2019, 11, 18, 12, 0, 0, 0, time.UTC)
+	b := NewBreaker(NewErrors(nil), 2, time.Minute, log.NewNopLogger())
+	b.now = func() time.Time { return now }
+	client := &http.Client{Transport: b}
+
+	get := func() error {
+		resp, err := client.Get(server.URL + "/status")
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := get(); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("call %d: expected an API error, got %v", i, err)
+		}
+	}
+	if err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected the circuit to be open, got %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected IEX Cloud not to be called while open, got %d calls", calls)
+	}
+
+	// The probe fails, the circuit opens again
+	now = now.Add(time.Minute)
+	if err := get(); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected the probe to fail, got %v", err)
+	}
+	if err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected the circuit to be open, got %v", err)
+	}
+
+	// The probe succeeds, the circuit closes
+	healthy = true
+	now = now.Add(time.Minute)
+	if err := get(); err != nil {
+		t.Fatalf("expected the probe to succeed, got %v", err)
+	}
+	if err := get(); err != nil {
+		t.Fatalf("expected the circuit to be closed, got %v", err)
+	}
+}
+
+// scriptedTransport returns the next error of the script, a 200 once it is
+// empty
+type scriptedTransport struct {
+	errs []error
+}
+
+func (s *scriptedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(s.errs) == 0 {
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+	}
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	return nil, err
+}
+
+func TestBreakerNeutralProbe(t *testing.T) {
+	upstream := errors.New("connection refused")
+	next := &scriptedTransport{errs: []error{
+		upstream,
+		// Probes which never reached IEX Cloud
+		context.Canceled,
+		ErrBudgetExhausted,
+		&WaitError{Err: context.DeadlineExceeded},
+	}}
+	now := time.Date(2019, 11, 18, 12, 0, 0, 0, time.UTC)
+	b := NewBreaker(next, 1, time.Minute, log.NewNopLogger())
+	b.now = func() time.Time { return now }
+
+	get := func() error {
+		resp, err := b.RoundTrip(httptest.NewRequest(http.MethodGet, "https://cloud.iexapis.com/stable/status", nil))
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if err := get(); err != upstream {
+		t.Fatalf("expected the upstream error, got %v", err)
+	}
+	now = now.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		if err := get(); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("probe %d: expected the probe to be let through, got %v", i, err)
+		}
+		if b.state != halfOpen || b.probing {
+			t.Fatalf("probe %d: expected the circuit to stay half-open without probe in flight", i)
+		}
+	}
+	if err := get(); err != nil {
+		t.Fatalf("expected the probe to succeed, got %v", err)
+	}
+	if b.state != closed {
+		t.Fatal("expected the circuit to be closed")
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package transport
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// Context binds every request to a context, as the IEX Cloud client does not
+// take one
+type Context struct {
+	ctx  context.Context
+	next http.RoundTripper
+}
+
+// WithContext returns a round tripper making its requests with ctx
+func WithContext(ctx context.Context, next http.RoundTripper) *Context {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Context{ctx: ctx, next: next}
+}
+
+// RoundTrip implements http.RoundTripper. The request is cancelled when
+// either its own context or the bound one is done, until the response body is
+// closed.
+func (c *Context) RoundTrip(req *http.Request) (*http.Response, error) {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if deadline, ok := c.ctx.Deadline(); ok {
+		ctx, cancel = context.WithDeadline(req.Context(), deadline)
+	} else {
+		ctx, cancel = context.WithCancel(req.Context())
+	}
+	// The deadline of the bound context is already set, so that the request
+	// fails with context.DeadlineExceeded
+	go func() {
+		select {
+		case <-c.ctx.Done():
+			if c.ctx.Err() == context.Canceled {
+				cancel()
+			}
+		case <-ctx.Done():
+		}
+	}()
+
+	resp, err := c.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody cancels the context of the request once the body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
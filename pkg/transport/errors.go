@@ -0,0 +1,128 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package transport
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// maxErrorBody limits how much of an error response is kept
+const maxErrorBody = 1024
+
+// APIError is returned for IEX Cloud responses with a non-200 status code
+type APIError struct {
+	StatusCode int
+	// Path of the endpoint, without the query string holding the token
+	Path string
+	Body string
+	// RetryAfter value of the Retry-After header, if any
+	RetryAfter string
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s: %d %s", e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+// Temporary reports whether the request may succeed if retried
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+var (
+	// tokenParam matches the token query parameter of a URL
+	tokenParam = regexp.MustCompile(`([?&]token=)[^&"\s]*`)
+	// tokenLike matches the IEX Cloud publishable and secret tokens, sandbox
+	// ones included, e.g. the keys of the account usage per token
+	tokenLike = regexp.MustCompile(`\bT?[ps]k_[0-9A-Za-z]{8,}\b`)
+)
+
+// redactedError is an error whose message no longer holds the API token
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// Redact hides the API token of the request URL quoted in the message of
+// err, and any other IEX Cloud token it holds. http.Client wraps every error,
+// an *APIError included, in a *url.Error quoting the URL with its query
+// string. The original error can still be unwrapped.
+func Redact(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := tokenLike.ReplaceAllString(tokenParam.ReplaceAllString(err.Error(), "${1}REDACTED"), "REDACTED")
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{err: err, msg: msg}
+}
+
+// Errors turns non-200 responses into an *APIError, keeping the status code
+// and the body which the IEX Cloud client would otherwise discard
+type Errors struct {
+	next http.RoundTripper
+}
+
+// NewErrors returns an Errors round tripper
+func NewErrors(next http.RoundTripper) *Errors {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Errors{next: next}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (e *Errors) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := e.next.RoundTrip(req)
+	if err != nil || resp.StatusCode == http.StatusOK {
+		return resp, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	return nil, &APIError{
+		StatusCode: resp.StatusCode,
+		Path:       req.URL.Path,
+		Body:       strings.TrimSpace(string(body)),
+		RetryAfter: resp.Header.Get("Retry-After"),
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unknown symbol", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: NewErrors(nil)}
+	_, err := client.Get(server.URL + "/stable//stock/aapl/price?token=sk_SECRET&format=json")
+	if err == nil || !strings.Contains(err.Error(), "sk_SECRET") {
+		t.Fatalf("expected the URL of the request in the error, got %v", err)
+	}
+
+	for _, err := range []error{err, fmt.Errorf("batch request failed: %w", err)} {
+		redacted := Redact(err)
+		if strings.Contains(redacted.Error(), "sk_SECRET") || !strings.Contains(redacted.Error(), "token=REDACTED&format=json") {
+			t.Errorf("expected the token to be redacted, got %s", redacted)
+		}
+		var apiErr *APIError
+		if !errors.As(redacted, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+			t.Errorf("expected the redacted error to unwrap to the API error, got %#v", apiErr)
+		}
+	}
+
+	if err := Redact(errors.New("unknown token Tsk_0123456789abcdef")); err.Error() != "unknown token REDACTED" {
+		t.Errorf("expected a token outside the URL to be redacted, got %s", err)
+	}
+	if err := errors.New("no token"); Redact(err) != err {
+		t.Error("expected an error without token to be returned as is")
+	}
+	if Redact(nil) != nil {
+		t.Error("expected nil to stay nil")
+	}
+}
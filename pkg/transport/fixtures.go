@@ -0,0 +1,116 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package transport
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Fixtures maps the request keys, the paths without the API version followed
+// by the query string without the token, to the JSON served for them, e.g.
+// "/stock/aapl/price". See Key.
+type Fixtures map[string]string
+
+// Get returns the fixture of the path, symbols are case insensitive
+func (f Fixtures) Get(path string) (string, bool) {
+	body, ok := f[strings.ToLower(path)]
+	return body, ok
+}
+
+// Merge returns the fixtures of f overridden by the ones of o
+func (f Fixtures) Merge(o Fixtures) Fixtures {
+	merged := make(Fixtures, len(f)+len(o))
+	for path, body := range f {
+		merged[path] = body
+	}
+	for path, body := range o {
+		merged[strings.ToLower(path)] = body
+	}
+	return merged
+}
+
+// LoadFixtures reads the JSON files of a directory, the fixture of
+// "/stock/aapl/price" is read from "<dir>/stock/aapl/price.json". See File for
+// the fixtures of requests with a query string.
+func LoadFixtures(dir string) (Fixtures, error) {
+	fixtures := make(Fixtures)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".json" {
+			return err
+		}
+		body, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, strings.TrimSuffix(path, ".json"))
+		if err != nil {
+			return err
+		}
+		key := strings.Replace("/"+filepath.ToSlash(rel), "@", "?", 1)
+		fixtures[strings.ToLower(key)] = string(body)
+		return nil
+	})
+	return fixtures, err
+}
+
+// versions are the API versions stripped from the request paths
+var versions = map[string]bool{"stable": true, "beta": true, "latest": true, "v1": true}
+
+// Path returns the path of a request without the API version
+func Path(p string) string {
+	p = path.Clean("/" + p)
+	parts := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 2)
+	if versions[parts[0]] {
+		if len(parts) == 1 {
+			return "/"
+		}
+		return "/" + parts[1]
+	}
+	return p
+}
+
+// Key returns the fixture key of a request: its path without the API version,
+// followed by the query string without the token, e.g.
+// "/stock/market/batch?symbols=aapl&types=price"
+func Key(u *url.URL) string {
+	q := u.Query()
+	q.Del("token")
+	key := Path(u.Path)
+	if len(q) > 0 {
+		key += "?" + q.Encode()
+	}
+	return strings.ToLower(key)
+}
+
+// File returns the file of the fixture in dir, the "?" of the query string
+// is replaced with "@"
+func File(dir, key string) string {
+	return filepath.Join(dir, filepath.FromSlash(strings.Replace(key, "?", "@", 1))+".json")
+}
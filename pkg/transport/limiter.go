@@ -0,0 +1,278 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package transport provides the http.RoundTripper middlewares used for the
+// IEX Cloud API calls.
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vglafirov/iexcloud_exporter/pkg/config"
+)
+
+// ErrBudgetExhausted is returned instead of calling IEX Cloud once the daily
+// message budget is spent
+var ErrBudgetExhausted = errors.New("daily message budget exhausted")
+
+// WaitError is returned when the context of a request is done while the
+// request waits for the rate limit, before calling IEX Cloud
+type WaitError struct {
+	Err error
+}
+
+func (e *WaitError) Error() string {
+	return "waiting for the rate limit: " + e.Err.Error()
+}
+
+// Unwrap returns the error of the context
+func (e *WaitError) Unwrap() error {
+	return e.Err
+}
+
+// Weights estimated message cost of the IEX Cloud data types, per symbol.
+// Data types which are not listed cost one message.
+var Weights = map[string]float64{
+	"account":   0,
+	"status":    0,
+	"price":     1,
+	"quote":     1,
+	"book":      1,
+	"ohlc":      2,
+	"previous":  2,
+	"stats":     5,
+	"chart":     10,
+	"dividends": 10,
+}
+
+// Weight returns the estimated message cost of a request to the given URL
+func Weight(u *url.URL) float64 {
+	var segments []string
+	for _, s := range strings.Split(u.Path, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	query := u.Query()
+
+	for i, s := range segments {
+		switch s {
+		case "account", "status":
+			return Weights[s]
+		case "stock":
+			if len(segments) < i+3 {
+				return 1
+			}
+			if segments[i+1] == "market" && segments[i+2] == "batch" {
+				var perSymbol float64
+				for _, t := range strings.Split(query.Get("types"), ",") {
+					perSymbol += weight(t)
+				}
+				return perSymbol * float64(len(strings.Split(query.Get("symbols"), ",")))
+			}
+			return weight(segments[i+2])
+		case "deep", "tops":
+			return float64(len(strings.Split(query.Get("symbols"), ",")))
+		}
+	}
+	return 1
+}
+
+func weight(dataType string) float64 {
+	if w, ok := Weights[dataType]; ok {
+		return w
+	}
+	return 1
+}
+
+var (
+	budgetRemaining = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "limiter", "budget_remaining_messages"),
+		"Estimated number of messages left in today's budget.",
+		nil, nil,
+	)
+	budgetSpent = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "limiter", "budget_spent_messages"),
+		"Estimated number of messages spent today.",
+		nil, nil,
+	)
+)
+
+// Limiter caps the number of requests per second and the estimated number of
+// messages spent per day (UTC). Requests over the rate wait for their turn,
+// requests over the budget fail with ErrBudgetExhausted.
+type Limiter struct {
+	next   http.RoundTripper
+	rate   float64
+	budget float64
+	now    func() time.Time
+
+	mtx    sync.Mutex
+	tokens float64
+	last   time.Time
+	day    string
+	spent  float64
+
+	throttled *prometheus.CounterVec
+}
+
+// NewLimiter returns a limiter of rate requests per second and budget
+// messages per day. Zero disables the corresponding limit.
+func NewLimiter(next http.RoundTripper, rate, budget float64) *Limiter {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Limiter{
+		next:   next,
+		rate:   rate,
+		budget: budget,
+		now:    time.Now,
+		throttled: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: config.Namespace,
+				Subsystem: "limiter",
+				Name:      "throttled_requests_total",
+				Help:      "Number of requests delayed by the rate limit or rejected by the message budget.",
+			},
+			[]string{"reason"},
+		),
+	}
+}
+
+// RoundTrip implements http.RoundTripper. The budget and the rate token of
+// a request given up while waiting for the rate limit are refunded.
+func (l *Limiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	weight := Weight(req.URL)
+	day, err := l.spend(weight)
+	if err != nil {
+		return nil, err
+	}
+	if wait := l.reserve(); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-req.Context().Done():
+			l.refund(weight, day)
+			return nil, &WaitError{Err: req.Context().Err()}
+		case <-timer.C:
+		}
+	}
+	return l.next.RoundTrip(req)
+}
+
+// spend takes the estimated cost of a request from today's budget and
+// returns the day it was taken from
+func (l *Limiter) spend(weight float64) (string, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if day := l.now().UTC().Format("2006-01-02"); day != l.day {
+		l.day, l.spent = day, 0
+	}
+	if l.budget > 0 && l.spent+weight > l.budget {
+		l.throttled.WithLabelValues("budget").Inc()
+		return "", fmt.Errorf("%w: %.0f of %.0f messages spent", ErrBudgetExhausted, l.spent, l.budget)
+	}
+	l.spent += weight
+	return l.day, nil
+}
+
+// refund gives back the cost of a request which was not sent and its rate
+// token. The cost is only given back to the day it was taken from.
+func (l *Limiter) refund(weight float64, day string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if day == l.day {
+		l.spent -= weight
+	}
+	if l.rate > 0 {
+		l.tokens++
+	}
+}
+
+// burst is the number of requests which can be made at once
+func (l *Limiter) burst() float64 {
+	if l.rate < 1 {
+		return 1
+	}
+	return l.rate
+}
+
+// reserve takes a token from the bucket and returns how long to wait for it
+func (l *Limiter) reserve() time.Duration {
+	if l.rate <= 0 {
+		return 0
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := l.now()
+	if l.last.IsZero() {
+		l.tokens = l.burst()
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+	}
+	if burst := l.burst(); l.tokens > burst {
+		l.tokens = burst
+	}
+	l.last = now
+
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	l.throttled.WithLabelValues("rate").Inc()
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// Describe implements prometheus.Collector.
+func (l *Limiter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- budgetRemaining
+	ch <- budgetSpent
+	l.throttled.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (l *Limiter) Collect(ch chan<- prometheus.Metric) {
+	l.mtx.Lock()
+	spent := l.spent
+	if l.now().UTC().Format("2006-01-02") != l.day {
+		spent = 0
+	}
+	l.mtx.Unlock()
+
+	if l.budget > 0 {
+		ch <- prometheus.MustNewConstMetric(budgetRemaining, prometheus.GaugeValue, l.budget-spent)
+	}
+	ch <- prometheus.MustNewConstMetric(budgetSpent, prometheus.GaugeValue, spent)
+	l.throttled.Collect(ch)
+}
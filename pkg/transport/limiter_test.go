@@ -0,0 +1,131 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestWeight(t *testing.T) {
+	tests := map[string]float64{
+		"https://cloud.iexapis.com/stable/stock/aapl/price?token=x":                                 1,
+		"https://cloud.iexapis.com/stable//stock/aapl/stats?token=x":                                5,
+		"https://cloud.iexapis.com/stable/stock/market/batch?symbols=aapl,msft&types=price,stats":   12,
+		"https://cloud.iexapis.com/stable/stock/market/batch?symbols=aapl&types=dividends&range=1y": 10,
+		"https://cloud.iexapis.com/stable/account/metadata?token=x":                                 0,
+		"https://cloud.iexapis.com/stable/deep/book?symbols=aapl,msft,ibm":                          3,
+		"https://cloud.iexapis.com/stable/ref-data/us/dates/trade/next/1?token=x":                   1,
+	}
+	for address, want := range tests {
+		u, err := url.Parse(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Weight(u); got != want {
+			t.Errorf("%s: expected %g, got %g", address, want, got)
+		}
+	}
+}
+
+func TestLimiterBudget(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	now := time.Date(2019, 11, 18, 12, 0, 0, 0, time.UTC)
+	l := NewLimiter(http.DefaultTransport, 0, 6)
+	l.now = func() time.Time { return now }
+	client := &http.Client{Transport: l}
+
+	get := func(path string) error {
+		resp, err := client.Get(server.URL + path)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if err := get("/stock/aapl/stats"); err != nil {
+		t.Fatal(err)
+	}
+	if err := get("/stock/aapl/price"); err != nil {
+		t.Fatal(err)
+	}
+	if err := get("/stock/aapl/price"); !errors.Is(err, ErrBudgetExhausted) {
+		t.Fatalf("expected the budget to be exhausted, got %v", err)
+	}
+
+	now = now.Add(24 * time.Hour)
+	if err := get("/stock/aapl/price"); err != nil {
+		t.Fatalf("expected the budget to be reset the next day, got %v", err)
+	}
+}
+
+func TestLimiterRate(t *testing.T) {
+	now := time.Date(2019, 11, 18, 12, 0, 0, 0, time.UTC)
+	l := NewLimiter(http.DefaultTransport, 2, 0)
+	l.now = func() time.Time { return now }
+
+	for i, want := range []time.Duration{0, 0, 500 * time.Millisecond, time.Second} {
+		if got := l.reserve(); got != want {
+			t.Errorf("request %d: expected to wait %s, got %s", i, want, got)
+		}
+	}
+}
+
+func TestLimiterRefund(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	now := time.Date(2019, 11, 18, 12, 0, 0, 0, time.UTC)
+	l := NewLimiter(http.DefaultTransport, 1, 2)
+	l.now = func() time.Time { return now }
+
+	resp, err := l.RoundTrip(httptest.NewRequest(http.MethodGet, server.URL+"/stock/aapl/price", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// The second request waits for the rate limit and is given up
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, server.URL+"/stock/aapl/price", nil).WithContext(ctx)
+	var waitErr *WaitError
+	if _, err := l.RoundTrip(req); !errors.As(err, &waitErr) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a cancelled wait, got %v", err)
+	}
+	if l.spent != 1 {
+		t.Errorf("expected the budget of the cancelled request to be refunded, got %g messages spent", l.spent)
+	}
+	if wait := l.reserve(); wait != time.Second {
+		t.Errorf("expected the rate token to be refunded, got a %s wait", wait)
+	}
+}
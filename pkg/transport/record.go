@@ -0,0 +1,149 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Recorder writes the successful responses to a fixture directory which can
+// be served by the fake API or replayed. The token of the request and any
+// other IEX Cloud token are redacted from the responses.
+type Recorder struct {
+	next http.RoundTripper
+	dir  string
+}
+
+// NewRecorder returns a Recorder writing to dir
+func NewRecorder(next http.RoundTripper, dir string) *Recorder {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Recorder{next: next, dir: dir}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	// Only whole JSON strings are replaced, a short token may be part of
+	// the field names
+	if token := req.URL.Query().Get("token"); token != "" {
+		body = bytes.Replace(body, []byte(`"`+token+`"`), []byte(`"REDACTED"`), -1)
+	}
+	body = redactTokens(body)
+	if err := r.write(File(r.dir, Key(req.URL)), body); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// redactTokens replaces the IEX Cloud tokens of body, e.g. the other tokens of
+// the account usage, keeping their prefix. Each token gets its own placeholder
+// so that the keys of the usage per token stay distinct.
+func redactTokens(body []byte) []byte {
+	placeholders := make(map[string][]byte)
+	return tokenLike.ReplaceAllFunc(body, func(token []byte) []byte {
+		placeholder, ok := placeholders[string(token)]
+		if !ok {
+			prefix := token[:bytes.IndexByte(token, '_')+1]
+			placeholder = []byte(fmt.Sprintf("%sREDACTED%d", prefix, len(placeholders)+1))
+			placeholders[string(token)] = placeholder
+		}
+		return placeholder
+	})
+}
+
+// write replaces the file atomically, concurrent requests of the same
+// endpoint leave one of the responses
+func (r *Recorder) write(path string, body []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".fixture")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(body); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Replay serves the requests from fixtures instead of calling the network,
+// the requests without a fixture get a 404. The recorded responses are served
+// as is, the other requests get the fixture of their path.
+type Replay struct {
+	fixtures Fixtures
+}
+
+// NewReplay returns a Replay of the fixtures
+func NewReplay(fixtures Fixtures) *Replay {
+	return &Replay{fixtures: fixtures}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Replay) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, ok := r.fixtures.Get(Key(req.URL))
+	if !ok {
+		body, ok = r.fixtures.Get(Path(req.URL.Path))
+	}
+	status, contentType := http.StatusOK, "application/json; charset=utf-8"
+	if !ok {
+		status, contentType, body = http.StatusNotFound, "text/plain; charset=utf-8", "Unknown symbol\n"
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{contentType}},
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
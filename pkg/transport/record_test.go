@@ -0,0 +1,174 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package transport
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+var recordFixtures = Fixtures{
+	"/stock/aapl/price": `261.78`,
+	"/account/usage":    `{"monthlyUsage":123456,"dailyUsage":{"20191110":23456},"tokenUsage":{"pk_0123456789abcdef":123456}}`,
+	"/stock/market/batch?symbols=aapl%2cmsft&types=price": `{"AAPL":{"price":261.78},"MSFT":{"price":149.97}}`,
+}
+
+// newFixtureServer returns a server of the fixtures standing for IEX Cloud
+func newFixtureServer(fixtures Fixtures) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := fixtures.Get(Key(r.URL))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := newFixtureServer(recordFixtures)
+	defer server.Close()
+
+	paths := []string{
+		"/stable//stock/AAPL/price?token=pk_0123456789abcdef",
+		"/stable//account/usage?token=pk_0123456789abcdef",
+		"/stable//stock/market/batch?symbols=AAPL%2CMSFT&types=price&token=pk_0123456789abcdef",
+	}
+	recorded := make([]string, len(paths))
+	client := &http.Client{Transport: NewRecorder(nil, dir)}
+	for i, path := range paths {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		recorded[i] = string(body)
+	}
+
+	for _, name := range []string{"stock/aapl/price.json", "account/usage.json", "stock/market/batch@symbols=aapl%2cmsft&types=price.json"} {
+		body, err := ioutil.ReadFile(dir + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(body), "pk_0123456789abcdef") {
+			t.Errorf("%s: expected the token to be redacted", name)
+		}
+	}
+
+	fixtures, err := LoadFixtures(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = &http.Client{Transport: NewReplay(fixtures)}
+	for i, path := range paths {
+		resp, err := client.Get("https://cloud.iexapis.com" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		want := strings.Replace(recorded[i], `"pk_0123456789abcdef"`, `"REDACTED"`, -1)
+		if resp.StatusCode != http.StatusOK || string(body) != want {
+			t.Errorf("%s: expected the recorded response, got %d %s", path, resp.StatusCode, body)
+		}
+	}
+
+	resp, err := client.Get("https://cloud.iexapis.com/stable/stock/msft/price?token=test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected a missing fixture to get a 404, got %d", resp.StatusCode)
+	}
+}
+
+// The other tokens of the account are redacted, each with its own placeholder
+func TestRecordOtherTokens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := newFixtureServer(Fixtures{
+		"/account/usage": `{"monthlyUsage":3,"tokenUsage":{"sk_0123456789abcdef":1,"pk_fedcba9876543210":1,"Tpk_00112233445566778899":1}}`,
+	})
+	defer server.Close()
+
+	client := &http.Client{Transport: NewRecorder(nil, dir)}
+	resp, err := client.Get(server.URL + "/stable//account/usage?token=sk_0123456789abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	body, err := ioutil.ReadFile(dir + "/account/usage.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"monthlyUsage":3,"tokenUsage":{"REDACTED":1,"pk_REDACTED1":1,"Tpk_REDACTED2":1}}`
+	if string(body) != want {
+		t.Errorf("expected %s, got %s", want, body)
+	}
+}
+
+// A token shorter than the IEX Cloud ones may be part of the field names
+func TestRecordShortToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := newFixtureServer(recordFixtures)
+	defer server.Close()
+
+	client := &http.Client{Transport: NewRecorder(nil, dir)}
+	resp, err := client.Get(server.URL + "/stable//account/usage?token=daily")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	body, err := ioutil.ReadFile(dir + "/account/usage.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `"dailyUsage"`) {
+		t.Errorf("expected the field names to be left alone, got %s", body)
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package transport
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vglafirov/iexcloud_exporter/pkg/config"
+)
+
+// maxRetryAfter caps the delay requested by a Retry-After header
+const maxRetryAfter = 5 * time.Minute
+
+// Retry retries requests which failed with a 429 or 5xx status code, waiting
+// for the Retry-After delay if IEX Cloud sent one or for a jittered
+// exponential backoff otherwise
+type Retry struct {
+	next       http.RoundTripper
+	maxRetries int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	now        func() time.Time
+
+	retries prometheus.Counter
+}
+
+// NewRetry returns a round tripper retrying failed requests up to maxRetries
+// times, starting with a backoff of the given duration
+func NewRetry(next http.RoundTripper, maxRetries int, backoff time.Duration) *Retry {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Retry{
+		next:       next,
+		maxRetries: maxRetries,
+		backoff:    backoff,
+		maxBackoff: 32 * backoff,
+		now:        time.Now,
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: config.Namespace,
+			Name:      "http_retries_total",
+			Help:      "Number of retried IEX Cloud requests.",
+		}),
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Retry) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := r.next.RoundTrip(req)
+
+		var apiErr *APIError
+		if err == nil || attempt >= r.maxRetries || !errors.As(err, &apiErr) || !apiErr.Temporary() {
+			return resp, err
+		}
+
+		timer := time.NewTimer(r.delay(attempt, apiErr.RetryAfter))
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+		r.retries.Inc()
+	}
+}
+
+// delay returns how long to wait before the next attempt
+func (r *Retry) delay(attempt int, retryAfter string) time.Duration {
+	if retryAfter != "" {
+		var d time.Duration
+		if seconds, err := strconv.Atoi(retryAfter); err == nil {
+			d = time.Duration(seconds) * time.Second
+		} else if t, err := http.ParseTime(retryAfter); err == nil {
+			d = t.Sub(r.now())
+		}
+		if d > maxRetryAfter {
+			d = maxRetryAfter
+		}
+		if d > 0 {
+			return d
+		}
+	}
+
+	backoff := r.backoff << uint(attempt)
+	if backoff > r.maxBackoff || backoff <= 0 {
+		backoff = r.maxBackoff
+	}
+	// Full jitter, so that the groups refreshed together do not retry in lockstep
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// Describe implements prometheus.Collector.
+func (r *Retry) Describe(ch chan<- *prometheus.Desc) {
+	r.retries.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (r *Retry) Collect(ch chan<- prometheus.Metric) {
+	r.retries.Collect(ch)
+}
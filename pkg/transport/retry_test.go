@@ -0,0 +1,113 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package transport
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch calls {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		case 2:
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		default:
+			w.Write([]byte("1.5"))
+		}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: NewRetry(NewErrors(nil), 3, time.Millisecond)}
+	resp, err := client.Get(server.URL + "/stock/aapl/price?token=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		status := http.StatusServiceUnavailable
+		if r.URL.Path == "/stock/work/price" {
+			status = http.StatusNotFound
+		}
+		http.Error(w, "Unknown symbol", status)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: NewRetry(NewErrors(nil), 2, time.Millisecond)}
+	_, err := client.Get(server.URL + "/stock/aapl/price?token=secret")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected an API error, got %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls)
+	}
+
+	calls = 0
+	_, err = client.Get(server.URL + "/stock/work/price?token=secret")
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Body != "Unknown symbol" {
+		t.Fatalf("expected an API error, got %v", err)
+	}
+	if apiErr.Path != "/stock/work/price" {
+		t.Errorf("unexpected path %q", apiErr.Path)
+	}
+	if calls != 1 {
+		t.Fatalf("expected client errors not to be retried, got %d calls", calls)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	now := time.Date(2019, 11, 18, 12, 0, 0, 0, time.UTC)
+	r := NewRetry(nil, 3, time.Second)
+	r.now = func() time.Time { return now }
+
+	if d := r.delay(0, "7"); d != 7*time.Second {
+		t.Errorf("expected the Retry-After seconds, got %s", d)
+	}
+	if d := r.delay(0, now.Add(time.Minute).Format(http.TimeFormat)); d != time.Minute {
+		t.Errorf("expected the Retry-After date, got %s", d)
+	}
+	for attempt := 0; attempt < 10; attempt++ {
+		if d := r.delay(attempt, ""); d < 0 || d > time.Second<<uint(attempt) || d > 32*time.Second {
+			t.Errorf("attempt %d: unexpected backoff %s", attempt, d)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/vglafirov/iexcloud_exporter/pkg/config"
+	"github.com/vglafirov/iexcloud_exporter/pkg/model"
+	"github.com/vglafirov/iexcloud_exporter/pkg/transport"
+)
+
+var (
+	probeSuccess = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "probe", "success"),
+		"Whether the probe succeeded.",
+		nil, nil,
+	)
+	probeDuration = prometheus.NewDesc(
+		prometheus.BuildFQName(config.Namespace, "probe", "duration_seconds"),
+		"Duration of the probe.",
+		nil, nil,
+	)
+)
+
+// probeSymbol is the symbol the modules are checked with, the actual symbols
+// come from the probe requests
+const probeSymbol = "AAPL"
+
+// checkModules checks that the modules can be configured
+func checkModules(modules map[string]config.Module) error {
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, err := newModule(modules[name], []string{probeSymbol}); err != nil {
+			return config.WrapError("modules."+name, err)
+		}
+	}
+	return nil
+}
+
+// newModule returns the collector of the module configured for the symbols
+func newModule(module config.Module, symbols []string) (model.Collector, error) {
+	c, err := model.New(module.Name)
+	if err != nil {
+		return nil, err
+	}
+	params, err := module.WithSymbols(symbols)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Configure(params); err != nil {
+		return nil, config.WrapError(module.Name, err)
+	}
+	return c, nil
+}
+
+// probe collects a module for the symbols of a /probe request
+type probe struct {
+	collector model.Collector
+	// rejected descriptors of the metrics left out by the global filter
+	rejected map[*prometheus.Desc]bool
+	client   model.ClientFunc
+	token    string
+	ctx      context.Context
+	logger   log.Logger
+}
+
+// Describe implements prometheus.Collector.
+func (p probe) Describe(ch chan<- *prometheus.Desc) {
+	ch <- probeSuccess
+	ch <- probeDuration
+	p.collector.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (p probe) Collect(ch chan<- prometheus.Metric) {
+	out := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		for m := range out {
+			if !p.rejected[m.Desc()] {
+				ch <- m
+			}
+		}
+		close(done)
+	}()
+	p.collect(out)
+	close(out)
+	<-done
+}
+
+// collect collects the module and sends the outcome of the probe
+func (p probe) collect(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	report := model.NewReport()
+	ctx := model.WithReport(p.ctx, report)
+	client := p.client(ctx, p.token)
+
+	var err error
+	if c, ok := p.collector.(model.BatchCollector); ok {
+		var result model.BatchResult
+		result, err = model.FetchBatch(ctx, client, c.BatchRequests())
+		if collectErr := c.CollectBatch(ctx, result, ch); err == nil {
+			err = collectErr
+		}
+	} else {
+		err = p.collector.Collect(ctx, client, ch)
+	}
+	if err == nil && report.Attempted() > 0 && report.Failed() == report.Attempted() {
+		err = errors.New("every symbol failed")
+	}
+	for _, f := range report.Failures() {
+		level.Debug(p.logger).Log("msg", "cannot probe symbol", "collector", p.collector.Name(), "symbol", f.Symbol, "endpoint", f.Endpoint, "err", f.Err)
+	}
+
+	success := 1.0
+	if err != nil {
+		level.Error(p.logger).Log("msg", "probe failed", "collector", p.collector.Name(), "err", transport.Redact(err))
+		success = 0
+	}
+	ch <- prometheus.MustNewConstMetric(probeDuration, prometheus.GaugeValue, time.Since(start).Seconds())
+	ch <- prometheus.MustNewConstMetric(probeSuccess, prometheus.GaugeValue, success)
+}
+
+// ProbeHandler collects the module of the request for its symbols, e.g.
+// /probe?module=keystats&symbol=AAPL&symbol=MSFT, within the scrape timeout
+func (e *Exporter) ProbeHandler(offset time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		name := query.Get("module")
+		if name == "" {
+			http.Error(w, "module parameter is missing", http.StatusBadRequest)
+			return
+		}
+		e.mtx.RLock()
+		module, ok := e.modules[name]
+		filter := e.filter
+		e.mtx.RUnlock()
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown module %q", name), http.StatusBadRequest)
+			return
+		}
+		var symbols []string
+		seen := make(map[string]bool)
+		for _, symbol := range query["symbol"] {
+			if !seen[symbol] {
+				seen[symbol] = true
+				symbols = append(symbols, symbol)
+			}
+		}
+		if len(symbols) == 0 {
+			http.Error(w, "symbol parameter is missing", http.StatusBadRequest)
+			return
+		}
+		if symbols = config.FilterSymbols(symbols, filter.Symbols); len(symbols) == 0 {
+			http.Error(w, "every symbol is filtered out", http.StatusBadRequest)
+			return
+		}
+		c, err := newModule(module, symbols)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid probe: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), scrapeTimeout(r, e.opts.timeout, offset))
+		defer cancel()
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(probe{
+			collector: c,
+			rejected:  model.Rejected(c, filter.Metrics.Match),
+			client:    e.newClient,
+			token:     module.Token,
+			ctx:       ctx,
+			logger:    e.logger,
+		})
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+			ErrorLog: &promHTTPLogger{
+				logger: e.logger,
+			},
+		}).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,145 @@
+/*
+Copyright (c) 2019 Vladimir Glafirov
+
+MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestProbe(t *testing.T) {
+	var (
+		mtx    sync.Mutex
+		tokens []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		tokens = append(tokens, r.URL.Query().Get("token"))
+		mtx.Unlock()
+		if r.URL.Path != "/stock/market/batch" {
+			http.NotFound(w, r)
+			return
+		}
+		var response []string
+		for i, symbol := range strings.Split(r.URL.Query().Get("symbols"), ",") {
+			response = append(response, fmt.Sprintf(`"%s": {"price": %d}`, symbol, 100+i))
+		}
+		fmt.Fprintf(w, "{%s}", strings.Join(response, ","))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "iexcloud_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yml")
+	token := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(token, []byte("sk_billed\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := "tokens:\n  billed:\n    file: " + token + "\nmodules:\n  price:\n    price: {}\n  billed:\n    price: {}\n    token: billed\n"
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := iexcloudOpts{endpoint: server.URL, configPath: path, apiToken: "test", timeout: time.Second}
+	e, err := NewExporter(opts, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		want   []string
+		token  string
+	}{
+		{
+			name:   "symbols",
+			query:  "module=price&symbol=AAPL&symbol=MSFT&symbol=AAPL",
+			status: http.StatusOK,
+			want:   []string{`iexcloud_price{symbol="AAPL"} 100`, `iexcloud_price{symbol="MSFT"} 101`, "iexcloud_probe_success 1"},
+			token:  "test",
+		},
+		{
+			name:   "module token",
+			query:  "module=billed&symbol=AAPL",
+			status: http.StatusOK,
+			want:   []string{`iexcloud_price{symbol="AAPL"} 100`, "iexcloud_probe_success 1"},
+			token:  "sk_billed",
+		},
+		{
+			name:   "unknown module",
+			query:  "module=prices&symbol=AAPL",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "no symbol",
+			query:  "module=price",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "invalid symbol",
+			query:  "module=price&symbol=A,B",
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mtx.Lock()
+			tokens = nil
+			mtx.Unlock()
+			rec := httptest.NewRecorder()
+			e.ProbeHandler(0).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/probe?"+test.query, nil))
+			if rec.Code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, rec.Code, rec.Body)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(rec.Body.String(), want) {
+					t.Errorf("expected %q in:\n%s", want, rec.Body)
+				}
+			}
+			mtx.Lock()
+			defer mtx.Unlock()
+			for _, token := range tokens {
+				if token != test.token {
+					t.Errorf("expected the requests to be billed to %q, got %q", test.token, token)
+				}
+			}
+		})
+	}
+}